@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// A request recorded in the -audit-log file, one JSON object per line
+type auditRecord struct {
+	Time       string                 `json:"time"`
+	Service    string                 `json:"service"`
+	Operation  string                 `json:"operation"`
+	Caller     string                 `json:"caller,omitempty"` // ARN of the base credentials
+	Region     string                 `json:"region,omitempty"`
+	Method     string                 `json:"method,omitempty"`
+	URL        string                 `json:"url,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Attempt    int                    `json:"attempt,omitempty"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	HTTPStatus int                    `json:"http_status,omitempty"`
+	Duration   float64                `json:"duration"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Parameters kept out of the audit log, as they're secrets rather than evidence
+var auditRedacted = map[string]bool{
+	"TokenCode":                true,
+	"WebIdentityToken":         true,
+	"SAMLAssertion":            true,
+	"SSECustomerKey":           true,
+	"CopySourceSSECustomerKey": true,
+}
+
+// The audit log, set from flags (nil when not auditing)
+var (
+	auditMu  sync.Mutex
+	auditOut *os.File
+)
+
+// Opens the audit log, appending so that the runs of an engagement accumulate in one file
+func openAuditLog(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	auditOut = f
+	return nil
+}
+
+// Writes the record as one line, synced so that it survives the run being killed
+func writeAudit(r auditRecord) {
+	if auditOut == nil {
+		return
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditOut.Write(append(b, '\n'))
+	auditOut.Sync()
+}
+
+// Returns the operation input as a map for the audit log, without the redacted
+// parameters and those that aren't set
+func auditParameters(params interface{}) map[string]interface{} {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if json.Unmarshal(b, &m) != nil {
+		return nil
+	}
+	for k, v := range m {
+		if v == nil || auditRedacted[k] {
+			delete(m, k)
+		}
+	}
+	return m
+}
+
+// Key of the operation input and attempt count carried from the initialize step to
+// each attempt
+type auditStateKey struct{}
+
+type auditState struct {
+	params   interface{}
+	attempts int
+}
+
+// Records every attempt of every request made by the clients built from the config.
+// The input is captured before the SDK serialises it, and each attempt is recorded
+// after the retry middleware, after the call budget and rate limiter have let it
+// through, so the log holds exactly what went on the wire.
+func applyAuditLog(cfg *aws.Config) {
+	if auditOut == nil {
+		return
+	}
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		err := stack.Initialize.Add(middleware.InitializeMiddlewareFunc("AuditParameters",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				ctx = middleware.WithStackValue(ctx, auditStateKey{}, &auditState{params: in.Parameters})
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+		if err != nil {
+			return err
+		}
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("AuditLog",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				start := time.Now()
+				out, md, err := next.HandleFinalize(ctx, in)
+
+				r := auditRecord{
+					Time:      start.UTC().Format(time.RFC3339Nano),
+					Service:   awsmiddleware.GetServiceID(ctx),
+					Operation: awsmiddleware.GetOperationName(ctx),
+					Caller:    callerIdentity.ARN,
+					Region:    awsmiddleware.GetRegion(ctx),
+					Duration:  time.Since(start).Seconds(),
+				}
+				if state, ok := middleware.GetStackValue(ctx, auditStateKey{}).(*auditState); ok {
+					state.attempts++
+					r.Parameters, r.Attempt = auditParameters(state.params), state.attempts
+				}
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					r.Method, r.URL = req.Method, req.URL.String()
+				}
+				if resp, ok := awsmiddleware.GetRawResponse(md).(*smithyhttp.Response); ok {
+					r.HTTPStatus = resp.StatusCode
+				}
+				r.RequestID, _ = awsmiddleware.GetRequestIDMetadata(md)
+				if err != nil {
+					r.Error = err.Error()
+					var respErr *awshttp.ResponseError
+					if errors.As(err, &respErr) {
+						r.HTTPStatus, r.RequestID = respErr.HTTPStatusCode(), respErr.ServiceRequestID()
+					}
+				}
+				writeAudit(r)
+				return out, md, err
+			}), middleware.After)
+	})
+}
+
+// Records a request made outside the SDK, such as the unsigned HEAD of region discovery
+func auditHTTP(service, operation string, req *http.Request, resp *http.Response, start time.Time, err error) {
+	if auditOut == nil {
+		return
+	}
+	r := auditRecord{
+		Time:      start.UTC().Format(time.RFC3339Nano),
+		Service:   service,
+		Operation: operation,
+		Caller:    callerIdentity.ARN,
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Duration:  time.Since(start).Seconds(),
+	}
+	if resp != nil {
+		r.HTTPStatus = resp.StatusCode
+		r.RequestID = resp.Header.Get("x-amz-request-id")
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	writeAudit(r)
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"gopkg.in/yaml.v3"
+)
+
+// A single batch target. Entries from a YAML targets file can override the
+// role, its external ID, key, region, known digits and base credentials profile per target; plain text entries only set Path.
+type target struct {
+	Path        string `yaml:"path"`
+	Key         string `yaml:"key"`
+	RoleArn     string `yaml:"role_arn"`
+	Region      string `yaml:"region"`
+	Label       string `yaml:"label"`
+	KnownDigits string `yaml:"known_digits"`
+	Profile     string `yaml:"profile"`
+	ExternalID  string `yaml:"external_id"`
+}
+
+// Returns the name a target is reported under
+func (t target) name() string {
+	if t.Label != "" {
+		return t.Label + " (" + t.Path + ")"
+	}
+	return t.Path
+}
+
+// Number of batch targets enumerated at once, set from flags
+var targetWorkers = 1
+
+// Enumerates the owning account of every target listed in the file, one result line per
+// target, returning the exit status
+func runBatch(ctx context.Context, cfg aws.Config, targetsFile, roleArn, knownDigits, region string) int {
+	targets, err := readTargets(ctx, cfg, targetsFile)
+	if err != nil {
+		fatalConfigf("failed to read targets: %v", err)
+	}
+	for i := range targets {
+		if targets[i].KnownDigits == "" {
+			targets[i].KnownDigits = knownDigits
+		}
+		if targets[i].Region == "" {
+			targets[i].Region = region
+		}
+	}
+	return runTargets(ctx, cfg, targets, roleArn)
+}
+
+// Enumerates the owners of targets found by the generate, ingest and scrape subcommands.
+// Without a role they have already been listed and there is nothing more to do.
+func runFoundTargets(targets []target, roleArn string) {
+	if roleArn == "" && !federation || len(targets) == 0 {
+		return
+	}
+
+	setPartitionFromRole(roleArn)
+	ctx, cancel := runContext()
+	defer cancel()
+	cfg := loadConfig(ctx)
+	lookupCallerIdentity(ctx, cfg)
+	initSameOrg(ctx, cfg)
+	if code := runTargets(ctx, cfg, targets, roleArn); code != exitFound {
+		os.Exit(code)
+	}
+}
+
+// A target normalised to its canonical bucket and key, with defaults applied
+type resolvedTarget struct {
+	target
+	Bucket string
+	Err    error
+}
+
+// Returns what identifies the search a target needs. Targets listing the same bucket
+// share a search only if they also have the same role, profile, key, external ID and
+// known digits.
+func (r resolvedTarget) jobKey() string {
+	return strings.Join([]string{r.Bucket, r.RoleArn, r.Profile, r.Key, r.ExternalID, r.KnownDigits}, "\x00")
+}
+
+// Returns the name a resolved target is reported under, showing the bucket it maps to
+func (r resolvedTarget) name() string {
+	if r.Err != nil || r.Bucket == r.Path {
+		return r.target.name()
+	}
+	return r.target.name() + " -> " + r.Bucket
+}
+
+// Enumerates the owning account of each target, printing one result line per target.
+// Targets are normalised to canonical buckets first so that each bucket is only
+// enumerated once per set of search settings, however many forms it was listed under.
+// Returns the exit status of
+// the most telling outcome: aborted, then failed, then partial.
+func runTargets(ctx context.Context, cfg aws.Config, targets []target, roleArn string) int {
+	start := time.Now()
+	resolved := make([]resolvedTarget, len(targets))
+	unique := make(map[string]bool)
+	for i, t := range targets {
+		resolved[i] = resolveTarget(t, roleArn)
+		if resolved[i].Err == nil {
+			unique[resolved[i].jobKey()] = true
+		}
+	}
+	if len(unique) < len(targets) {
+		slog.Info("Targets normalised to unique searches", "targets", len(targets), "searches", len(unique))
+	}
+
+	// Each unique search is run once, by the first target needing it, on a pool of
+	// targetWorkers goroutines. Results are printed in target order as they complete.
+	type result struct {
+		accountID string
+		status    string
+		err       error
+		elapsed   time.Duration
+		ran       bool
+		done      chan struct{}
+	}
+	results := make(map[string]*result)
+	var jobs []resolvedTarget
+	for _, r := range resolved {
+		if r.Err == nil && results[r.jobKey()] == nil {
+			results[r.jobKey()] = &result{done: make(chan struct{})}
+			jobs = append(jobs, r)
+		}
+	}
+
+	var dash *dashboard
+	if useDashboard {
+		buckets := make([]string, len(jobs))
+		for i, r := range jobs {
+			buckets[i] = r.Bucket
+		}
+		ctx, dash = newDashboard(ctx, buckets)
+	}
+
+	finished := make(chan string, len(jobs)) // Job keys in the order the searches complete
+	go func() {
+		runPool(ctx, targetWorkers, len(jobs), func(ctx context.Context, i int) {
+			r := jobs[i]
+			res := results[r.jobKey()]
+			start := time.Now()
+			emitEvent(progressEvent{Event: "target_started", Bucket: r.Bucket})
+			if dash != nil {
+				ctx = dash.begin(ctx, i)
+			}
+			ctx = withExternalID(ctx, r.ExternalID)
+			if targetCfg, err := configForProfile(ctx, cfg, r.Profile); err != nil {
+				res.err = err
+			} else {
+				res.accountID, res.status, _, res.err = findAccountID(ctx, targetCfg, r.Bucket, r.Key, r.RoleArn, r.KnownDigits)
+				if res.err == nil {
+					lookupOrgAccount(ctx, targetCfg, res.accountID)
+				}
+			}
+			if dash != nil {
+				res.err = dash.finish(i, res.accountID, res.status, res.err)
+			}
+			res.elapsed = time.Since(start)
+			result := batchResult(r, res.accountID, res.status, res.elapsed, res.err)
+			recordFinished(result)
+			res.ran = true
+			close(res.done)
+			finished <- r.jobKey()
+		})
+		// Targets the pool never reached because the run was cancelled
+		for _, r := range jobs {
+			if res := results[r.jobKey()]; !res.ran {
+				res.err = ctx.Err()
+				close(res.done)
+				finished <- r.jobKey()
+			}
+		}
+		if dash != nil {
+			dash.close()
+		}
+	}()
+	if dash != nil {
+		dash.run()
+	}
+
+	// Once every target has been printed
+	finish := func() int {
+		if showSummary {
+			printBatchSummary(resolved, func(r resolvedTarget) (string, string, error) {
+				res := results[r.jobKey()]
+				return res.accountID, res.status, res.err
+			}, time.Since(start))
+		}
+		printStats()
+		return batchExitCode(resolved, func(r resolvedTarget) (string, error) {
+			return results[r.jobKey()].accountID, results[r.jobKey()].err
+		})
+	}
+	if outputFormat != "text" {
+		// Every target needing a search gets a line as soon as the search is done
+		for _, r := range resolved {
+			if r.Err != nil {
+				printResultLine(batchResult(r, "", "", 0, r.Err))
+			}
+		}
+		for range jobs {
+			key := <-finished
+			res := results[key]
+			for _, r := range resolved {
+				if r.Err == nil && r.jobKey() == key {
+					printResultLine(batchResult(r, res.accountID, res.status, res.elapsed, res.err))
+				}
+			}
+		}
+		return finish()
+	}
+
+	for _, r := range resolved {
+		if r.Err != nil {
+			colorPrintf(os.Stdout, colorRed, "%s: error: %v\n", r.name(), r.Err)
+			continue
+		}
+		res := results[r.jobKey()]
+		<-res.done
+		if res.err != nil && res.accountID != "" {
+			colorPrintf(os.Stdout, colorYellow, "%s: %s (partial, error: %v)\n", r.name(), res.accountID, res.err)
+			continue
+		}
+		if res.err != nil {
+			colorPrintf(os.Stdout, colorRed, "%s: error: %v\n", r.name(), res.err)
+			continue
+		}
+		if len(res.accountID) < 12 {
+			colorPrintf(os.Stdout, colorYellow, "%s: %s (partial)\n", r.name(), res.accountID)
+			continue
+		}
+		colorPrintf(os.Stdout, statusColor(res.status), "%s: %s%s%s\n", r.name(), res.accountID, statusSuffix(res.status), ownerSuffix(res.accountID))
+	}
+	return finish()
+}
+
+// Returns the exit status of a batch run once every target has finished, given the
+// outcome of each target. Targets that couldn't be parsed count as configuration errors.
+func batchExitCode(resolved []resolvedTarget, outcome func(r resolvedTarget) (string, error)) int {
+	code := exitFound
+	for _, r := range resolved {
+		if r.Err != nil {
+			code = worseExitCode(code, exitConfigError)
+			continue
+		}
+		accountID, err := outcome(r)
+		code = worseExitCode(code, exitCodeFor(accountID, err))
+	}
+	return code
+}
+
+// Builds the JSON line for a batch target
+func batchResult(r resolvedTarget, accountID, status string, elapsed time.Duration, err error) runResult {
+	res := newRunResult(r.Bucket, r.Key, accountID, status, elapsed, err)
+	res.Path, res.Label = r.Path, r.Label
+	return res
+}
+
+// Normalises a target to its canonical bucket and key, applying the default role
+// and caching any region hint
+func resolveTarget(t target, defaultRoleArn string) resolvedTarget {
+	r := resolvedTarget{target: t}
+	bucket, key, err := parseTarget(t.Path)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Bucket = bucket
+	if r.Key == "" {
+		r.Key = key
+	}
+	if r.RoleArn == "" {
+		r.RoleArn = defaultRoleArn
+	}
+	if r.RoleArn == "" && !federation {
+		r.Err = fmt.Errorf("no role_arn given for target")
+		return r
+	}
+	if err := validateKnownDigits(r.KnownDigits); err != nil {
+		r.Err = err
+		return r
+	}
+	if r.Region != "" {
+		// Region hints save the lookup entirely
+		bucketRegions.set(bucket, r.Region)
+	}
+	return r
+}
+
+// Reads the targets file, either from disk or, for s3:// locations, from S3 using
+// the base credentials. Files ending in .yaml or .yml hold a list of target
+// entries; anything else is read as one path per line.
+func readTargets(ctx context.Context, cfg aws.Config, filename string) ([]target, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(filename, "s3://") {
+		data, err = readS3Object(ctx, cfg, filename)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return parseYAMLTargets(data)
+	}
+	return parseTextTargets(data)
+}
+
+// Downloads an object given as an s3:// URI
+func readS3Object(ctx context.Context, cfg aws.Config, uri string) ([]byte, error) {
+	bucket, key := splitBucketKey(strings.TrimPrefix(uri, "s3://"))
+	if key == "" {
+		return nil, fmt.Errorf("%s doesn't name an object", uri)
+	}
+
+	s3Svc := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = currentPartition().defaultRegion // Default region for S3
+	}, withS3Endpoint)
+	region, err := manager.GetBucketRegion(ctx, s3Svc, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	s3Svc = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = region
+		o.UsePathStyle = pathStyle
+	}, withS3Endpoint)
+	out, err := s3Svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+// Parses a YAML list of target entries
+func parseYAMLTargets(data []byte) ([]target, error) {
+	var targets []target
+	if err := yaml.Unmarshal(data, &targets); err != nil {
+		return nil, err
+	}
+	for i, t := range targets {
+		if t.Path == "" {
+			return nil, fmt.Errorf("target %d has no path", i+1)
+		}
+	}
+	return targets, nil
+}
+
+// Parses one path per line, skipping blank lines and # comments
+func parseTextTargets(data []byte) ([]target, error) {
+	var targets []target
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, target{Path: line})
+	}
+	return targets, scanner.Err()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Most AWS requests allowed in a run, set from flags (0 means unlimited)
+var maxAPICalls int64
+
+var (
+	apiCalls    atomic.Int64 // AWS requests made so far, retries included
+	budgetHit   atomic.Bool  // Set once a request has been refused for lack of budget
+	errNoBudget = errors.New("API call budget exhausted")
+)
+
+// Counts a request, refusing it once the budget is spent
+func chargeAPICall() error {
+	if apiCalls.Add(1) > maxAPICalls && maxAPICalls > 0 {
+		budgetHit.Store(true)
+		return errNoBudget
+	}
+	return nil
+}
+
+// Reports whether a request has been refused because the budget ran out. Probe
+// results from then on are meaningless, so searches stop and report what they have.
+func budgetExhausted() bool {
+	return budgetHit.Load()
+}
+
+// Adds the call counter and budget check to every client built from the config. Like
+// the rate limiter it sits after the retry middleware, so each attempt is counted.
+func applyCallBudget(cfg *aws.Config) {
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("CallBudget",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				if err := chargeAPICall(); err != nil {
+					return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+				}
+				return next.HandleFinalize(ctx, in)
+			}), middleware.After)
+	})
+}
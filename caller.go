@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// The identity of the base credentials, looked up once at startup so that results and
+// the audit log can be attributed when several testers share the tooling
+var callerIdentity struct {
+	Account string
+	ARN     string
+}
+
+// Looks up the caller identity. Failing doesn't stop the run, the results just aren't
+// attributed.
+func lookupCallerIdentity(ctx context.Context, cfg aws.Config) {
+	out, err := stsClientFor(cfg, "").GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		slog.Warn("Failed to look up the caller identity, results won't be attributed to it", "err", err)
+		return
+	}
+	callerIdentity.Account, callerIdentity.ARN = aws.ToString(out.Account), aws.ToString(out.Arn)
+	slog.Debug("Caller identity", "account", callerIdentity.Account, "arn", callerIdentity.ARN)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Bucket names typical of canary tokens, honeypots and other deception infrastructure
+var canaryNameRegexp = regexp.MustCompile(`(?i)canary|honey|decoy|tripwire|deception|thinkst|(^|[-_.])(trap|bait|lure)([-_.]|$)`)
+
+// Looks for signs that the bucket is a canary: a name like those of known deception
+// tooling, or a bucket that anyone can read without credentials, which real data
+// rarely is and bait often is. Returns a reason for each sign found.
+func canaryWarnings(ctx context.Context, cfg aws.Config, bucket string) []string {
+	var warnings []string
+	if m := canaryNameRegexp.FindString(bucket); m != "" {
+		warnings = append(warnings, fmt.Sprintf("the name contains %q, typical of canary tokens and honeypots", m))
+	}
+	if strings.HasPrefix(bucket, "arn:") || isDirectoryBucket(bucket) || isMultiRegionAccessPoint(bucket) {
+		return warnings
+	}
+
+	resp, err := anonymousHead(ctx, cfg, bucket)
+	if err != nil {
+		return warnings
+	}
+	if region := resp.Header.Get("X-Amz-Bucket-Region"); region != "" {
+		// Saves looking the region up again for the probes
+		bucketRegions.setDefault(bucket, region)
+	}
+	if resp.StatusCode == http.StatusOK {
+		warnings = append(warnings, "it can be accessed anonymously, without any credentials")
+	}
+	return warnings
+}
+
+// Whether to probe buckets that look like canaries anyway, set from flags
+var probeCanaries bool
+
+// Returned, as part of a longer message, for buckets that look like canaries
+var errCanary = errors.New("may be a canary")
+
+// Checks the bucket for signs of a canary before probing it, returning an error
+// unless -probe-canaries is given
+func checkCanary(ctx context.Context, cfg aws.Config, bucket string) error {
+	warnings := canaryWarnings(ctx, cfg, bucket)
+	if len(warnings) == 0 {
+		return nil
+	}
+	reasons := strings.Join(warnings, "; ")
+	if probeCanaries {
+		slog.Warn("Bucket may be a canary, probing anyway", "bucket", bucket, "reasons", reasons)
+		return nil
+	}
+	return fmt.Errorf("%s %w (%s); probing it could alert its owner, use -probe-canaries to probe anyway", bucket, errCanary, reasons)
+}
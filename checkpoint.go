@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Progress of a run, written to the checkpoint file so an interrupted run can resume
+type checkpointState struct {
+	Completed map[string]string   `json:"completed"` // Bucket to the account ID (or partial prefix) found
+	Partial   map[string]string   `json:"partial"`   // Bucket to the digits found so far
+	RuledOut  map[string]ruledOut `json:"ruled_out"` // Bucket to the digits denied at the position being searched
+	Regions   map[string]string   `json:"regions"`   // Bucket region cache
+}
+
+// Digits already ruled out as the one following Prefix
+type ruledOut struct {
+	Prefix string   `json:"prefix"`
+	Digits []string `json:"digits"`
+}
+
+// The checkpoint being written, set from flags (nil disables checkpointing)
+var (
+	checkpoint     *checkpointState
+	checkpointFile string
+	checkpointMu   sync.Mutex
+)
+
+// Starts checkpointing to filename, first loading the state of a previous run from
+// resumeFile if one is given
+func initCheckpoint(filename, resumeFile string) error {
+	state := &checkpointState{
+		Completed: make(map[string]string),
+		Partial:   make(map[string]string),
+		RuledOut:  make(map[string]ruledOut),
+		Regions:   make(map[string]string),
+	}
+	if resumeFile != "" {
+		data, err := os.ReadFile(resumeFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, state); err != nil {
+			return fmt.Errorf("%s: %v", resumeFile, err)
+		}
+		if state.RuledOut == nil {
+			state.RuledOut = make(map[string]ruledOut)
+		}
+		for bucket, region := range state.Regions {
+			bucketRegions.set(bucket, region)
+		}
+		slog.Info("Resuming from the checkpoint", "file", resumeFile, "completed", len(state.Completed), "in_progress", len(state.Partial))
+		if filename == "" {
+			filename = resumeFile
+		}
+	}
+	if filename == "" {
+		return nil
+	}
+	checkpoint, checkpointFile = state, filename
+	return nil
+}
+
+// Returns the result of a bucket completed in a previous run
+func completedAccountID(bucket string) (string, bool) {
+	if checkpoint == nil {
+		return "", false
+	}
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	accountID, ok := checkpoint.Completed[bucket]
+	return accountID, ok
+}
+
+// Returns the digits to start the bucket's search from: the prefix reached by a
+// previous run if it extends the known digits, otherwise the known digits
+func resumeDigits(bucket, knownDigits string) string {
+	if checkpoint == nil {
+		return knownDigits
+	}
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	if prefix := checkpoint.Partial[bucket]; len(prefix) > len(knownDigits) && strings.HasPrefix(prefix, knownDigits) {
+		return prefix
+	}
+	return knownDigits
+}
+
+// Records the digits found so far for a bucket
+func recordProgress(bucket, prefix string) {
+	if checkpoint == nil {
+		return
+	}
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	checkpoint.Partial[bucket] = prefix
+	delete(checkpoint.RuledOut, bucket)
+	saveCheckpoint()
+}
+
+// Returns the digits a previous run already ruled out as the one following prefix
+func ruledOutDigits(bucket, prefix string) map[string]bool {
+	denied := make(map[string]bool)
+	if checkpoint == nil {
+		return denied
+	}
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	if r := checkpoint.RuledOut[bucket]; r.Prefix == prefix {
+		for _, digit := range r.Digits {
+			denied[digit] = true
+		}
+	}
+	return denied
+}
+
+// Records digits that can't follow prefix, so a resumed run doesn't probe them again.
+// Probes that were cancelled or refused for lack of budget prove nothing and are
+// left out.
+func recordRuledOut(ctx context.Context, bucket, prefix string, digits []string) {
+	if checkpoint == nil || ctx.Err() != nil || budgetExhausted() {
+		return
+	}
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	r := checkpoint.RuledOut[bucket]
+	if r.Prefix != prefix {
+		r = ruledOut{Prefix: prefix}
+	}
+	for _, digit := range digits {
+		if !slices.Contains(r.Digits, digit) {
+			r.Digits = append(r.Digits, digit)
+		}
+	}
+	checkpoint.RuledOut[bucket] = r
+	saveCheckpoint()
+}
+
+// Records a bucket whose search has finished
+func recordCompleted(bucket, accountID string) {
+	if checkpoint == nil {
+		return
+	}
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	delete(checkpoint.Partial, bucket)
+	delete(checkpoint.RuledOut, bucket)
+	checkpoint.Completed[bucket] = accountID
+	saveCheckpoint()
+}
+
+// Writes the checkpoint file, replacing it atomically so an interruption mid-write
+// can't corrupt it. Called with checkpointMu held.
+func saveCheckpoint() {
+	for bucket, region := range bucketRegions.snapshot() {
+		checkpoint.Regions[bucket] = region
+	}
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode checkpoint", "err", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(checkpointFile), ".checkpoint-*")
+	if err != nil {
+		slog.Error("Failed to write checkpoint", "err", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), checkpointFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		slog.Error("Failed to write checkpoint", "err", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Clients shared by every probe, keyed by region. Each probe assumes the role with its
+// own session policy, so credentials are passed per operation with withCredentials
+// rather than baked into the client; connections and client setup are reused.
+var (
+	stsClients = newSharedCache[*sts.Client]()
+	s3Clients  = newSharedCache[*s3.Client]()
+)
+
+// Whether to address plain bucket names path-style, set from flags. Bucket names
+// containing dots don't match the S3 wildcard certificate with virtual-hosted addressing.
+var pathStyle bool
+
+// Returns the shared STS client for the region ("" for the configured region). STS
+// clients sign AssumeRole with the config's base credentials, so batch targets with
+// their own profile get their own clients, keyed by the credentials cache.
+func stsClientFor(cfg aws.Config, region string) *sts.Client {
+	cacheKey := fmt.Sprintf("%s/%p", region, cfg.Credentials)
+	if client, ok := stsClients.get(cacheKey); ok {
+		return client
+	}
+	client := sts.NewFromConfig(cfg, withSTSEndpoint, func(o *sts.Options) {
+		if region != "" {
+			o.Region = region
+		}
+	})
+	stsClients.set(cacheKey, client)
+	return client
+}
+
+// Returns the shared S3 client for probing a bucket in the region. Multi-Region Access
+// Point aliases get a client of their own that routes to the global endpoint, and
+// path-style addressing only applies to plain bucket names.
+func s3ClientFor(cfg aws.Config, region, bucket string) *s3.Client {
+	mrapAlias := isMRAPAlias(bucket)
+	usePathStyle := pathStyle && !mrapAlias && !strings.HasPrefix(bucket, "arn:") &&
+		!isAccessPointAlias(bucket) && !isDirectoryBucket(bucket)
+	cacheKey := region
+	if mrapAlias {
+		cacheKey += "/mrap"
+	} else if usePathStyle {
+		cacheKey += "/path"
+	}
+
+	if client, ok := s3Clients.get(cacheKey); ok {
+		return client
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = region
+		o.UseARNRegion = true
+		o.UsePathStyle = usePathStyle
+		if mrapAlias {
+			o.EndpointResolverV2 = &mrapAliasEndpointResolver{s3.NewDefaultEndpointResolverV2()}
+		}
+	}, withS3Endpoint)
+	s3Clients.set(cacheKey, client)
+	return client
+}
+
+// S3 endpoint URL to use instead of the SDK's, set from flags, e.g. LocalStack or moto.
+// Buckets are addressed path-style there, as the emulators don't resolve bucket hostnames.
+var s3EndpointURL string
+
+// Points an S3 client at -s3-endpoint, if given
+func withS3Endpoint(o *s3.Options) {
+	if s3EndpointURL != "" {
+		o.BaseEndpoint = aws.String(s3EndpointURL)
+		o.UsePathStyle = true
+	}
+}
+
+// STS endpoint URL to use instead of the SDK's, set from flags, e.g. for a private VPC endpoint
+var stsEndpoint string
+
+// Points an STS client at -sts-endpoint, if given. Requests are still signed for the
+// client's region.
+func withSTSEndpoint(o *sts.Options) {
+	if stsEndpoint != "" {
+		o.BaseEndpoint = aws.String(stsEndpoint)
+	}
+}
+
+// Sets the credentials for a single operation on a shared client
+func withCredentials(creds aws.CredentialsProvider) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.Credentials = creds
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Places an S3 error response can give away the bucket behind a distribution:
+// XML errors (<BucketName>, <Bucket>, <Endpoint>) and website endpoint HTML errors (BucketName: ...)
+var s3ErrorBucketRegexps = []*regexp.Regexp{
+	regexp.MustCompile(`<BucketName>([^<]+)</BucketName>`),
+	regexp.MustCompile(`<Bucket>([^<]+)</Bucket>`),
+	regexp.MustCompile(`<Endpoint>([^<]+)</Endpoint>`),
+	regexp.MustCompile(`BucketName:\s*([a-z0-9][a-z0-9.\-]+)`),
+}
+
+// Reports whether the host is a CloudFront distribution domain
+func isCloudFrontHost(host string) bool {
+	return strings.HasSuffix(host, ".cloudfront.net")
+}
+
+// Determines whether a CloudFront-served host has an S3 origin and returns that bucket.
+// When the origin is S3 but the bucket name can't be derived, the host itself is
+// returned since website buckets are often named after the domain they serve.
+func resolveCloudFrontOrigin(host string) string {
+	bucket, region, isS3 := inspectCloudFrontOrigin(host)
+	switch {
+	case !isS3:
+		slog.Warn("Served by CloudFront but the origin doesn't look like S3", "host", host)
+		return host
+	case bucket == "":
+		slog.Warn("S3 origin behind CloudFront but the bucket name couldn't be derived", "host", host)
+		bucket = host
+	default:
+		slog.Info("Found the S3 bucket behind CloudFront", "host", host, "bucket", bucket)
+	}
+	if region != "" {
+		bucketRegions.set(bucket, region)
+	}
+	return bucket
+}
+
+// Requests a key that shouldn't exist through the distribution, unauthenticated, and
+// inspects the response headers, redirect and error body for signs of an S3 origin
+func inspectCloudFrontOrigin(host string) (bucket, region string, isS3 bool) {
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: newHTTPTransport(),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	probe := make([]byte, 8)
+	rand.Read(probe)
+	resp, err := client.Get("https://" + host + "/s3accountfinder-" + hex.EncodeToString(probe))
+	if err != nil {
+		slog.Error("Failed to inspect the CloudFront origin", "host", host, "err", err)
+		return "", "", false
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	region = resp.Header.Get("x-amz-bucket-region")
+	isS3 = resp.Header.Get("Server") == "AmazonS3" || region != "" ||
+		resp.Header.Get("x-amz-request-id") != "" || strings.Contains(string(body), "<RequestId>")
+
+	// A redirect to an S3 endpoint names the bucket outright
+	if location := resp.Header.Get("Location"); location != "" {
+		if u, err := url.Parse(location); err == nil && isS3Host(strings.ToLower(u.Hostname())) {
+			b, _ := urlToS3Args(location)
+			return b, region, true
+		}
+	}
+
+	for _, re := range s3ErrorBucketRegexps {
+		match := re.FindStringSubmatch(string(body))
+		if match == nil {
+			continue
+		}
+		candidate := strings.ToLower(strings.TrimSpace(match[1]))
+		if isS3Host(candidate) {
+			candidate, _ = urlToS3Args(candidate)
+		}
+		if bucketNameRegexp.MatchString(candidate) {
+			return candidate, region, true
+		}
+	}
+	return "", region, isS3
+}
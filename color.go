@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Whether colour is turned off, set from flags. NO_COLOR in the environment also turns it off.
+var noColor bool
+
+// ANSI colours of the output lines
+const (
+	colorGreen  = "32" // Account IDs found and confirmed
+	colorYellow = "33" // Partial or unconfirmed results
+	colorRed    = "31" // Failures
+	colorCyan   = "36" // Digits found along the way
+)
+
+// Reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Reports whether lines written to f should be coloured: only on a terminal, so that
+// pipes and files get plain text
+func colorEnabled(f *os.File) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// Wraps s in the colour if f is coloured
+func paint(f *os.File, color, s string) string {
+	if !colorEnabled(f) {
+		return s
+	}
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
+
+// Prints a line to f in the colour, keeping the trailing newline outside the colour
+func colorPrintf(f *os.File, color, format string, a ...interface{}) {
+	line := fmt.Sprintf(format, a...)
+	trimmed := strings.TrimSuffix(line, "\n")
+	fmt.Fprint(f, paint(f, color, trimmed)+line[len(trimmed):])
+}
+
+// Returns the colour of a complete account ID with the status
+func statusColor(status string) string {
+	if status == statusUnconfirmed {
+		return colorYellow
+	}
+	return colorGreen
+}
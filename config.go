@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// SDK retry settings, set from flags (0 attempts keeps the SDK default)
+var (
+	maxAttempts int
+	retryMode   = string(aws.RetryModeStandard)
+)
+
+// Endpoint variants for every client and raw request, set from flags
+var (
+	useFIPS      bool
+	useDualStack bool
+)
+
+// Limits on a single probe (AssumeRole, region lookup and S3 call together) and on
+// the whole run, set from flags (0 means no limit)
+var (
+	requestTimeout time.Duration
+	overallTimeout time.Duration
+)
+
+// Throttling errors from STS and S3, retried with jittered backoff like any other
+// transient error. Bulk probing hits these routinely.
+var throttleErrorCodes = map[string]struct{}{
+	"Throttling":           {},
+	"ThrottlingException":  {},
+	"RequestLimitExceeded": {},
+	"SlowDown":             {},
+}
+
+// Registers the flags controlling AWS requests, shared by the main command and the subcommands
+func addRequestFlags(fs *flag.FlagSet) {
+	fs.Func("policy-actions", "comma-separated actions granted by the session policies (default: only those the probe needs)", func(s string) error {
+		policyActions = strings.Split(s, ",")
+		return nil
+	})
+	fs.Func("session-policy-file", "JSON policy whose Deny statements, and AllowResourceAccount condition, are added to every session policy", loadSessionPolicy)
+	fs.BoolVar(&federation, "federation", false, "get probe sessions with GetFederationToken from the base IAM user instead of assuming a role")
+	fs.StringVar(&sessionName, "session-name", "", "role session name for the assumed sessions, e.g. an engagement ticket number (default: the SDK's)")
+	fs.StringVar(&randomSessionName, "random-session-name", "", "add a random suffix to the session name once per run (run) or for every probe (probe)")
+	fs.StringVar(&sourceIdentity, "source-identity", "", "source identity of the assumed sessions, for roles that require sts:SourceIdentity")
+	fs.IntVar(&sessionDuration, "duration-seconds", 0, "lifetime of the assumed sessions, 900 to 43200 seconds within the role's maximum (0 for the SDK default of 900)")
+	fs.StringVar(&externalID, "external-id", "", "external ID required by the role's trust policy")
+	fs.StringVar(&awsConfigFile, "aws-config", "", "shared config file to read instead of ~/.aws/config (or AWS_CONFIG_FILE)")
+	fs.StringVar(&awsCredentialsFile, "aws-credentials", "", "shared credentials file to read instead of ~/.aws/credentials (or AWS_SHARED_CREDENTIALS_FILE)")
+	fs.StringVar(&mfaSerial, "mfa-serial", "", "ARN of the MFA device, for roles whose trust policy requires MFA")
+	fs.StringVar(&mfaToken, "mfa-token", "", "MFA token code (prompted for if -mfa-serial is given without it)")
+	fs.StringVar(&profile, "profile", "", "shared config profile for the base credentials (default: the default chain, including AWS_PROFILE)")
+	fs.StringVar(&accessKey, "access-key", "", "access key ID of static base credentials (AWS_ACCESS_KEY_ID also works, and keeps it out of the process list)")
+	fs.StringVar(&secretKey, "secret-key", "", "secret access key of static base credentials (or AWS_SECRET_ACCESS_KEY)")
+	fs.StringVar(&sessionToken, "session-token", "", "session token of temporary static base credentials (or AWS_SESSION_TOKEN)")
+	fs.StringVar(&webIdentityTokenFile, "web-identity-token-file", "", "OIDC token file exchanged with AssumeRoleWithWebIdentity for the base credentials, e.g. in GitHub Actions or EKS")
+	fs.StringVar(&webIdentityRoleArn, "web-identity-role-arn", webIdentityRoleArn, "role assumed with the web identity token (default: AWS_ROLE_ARN)")
+	fs.StringVar(&credentialSource, "credential-source", "", "take the base credentials only from the EC2 instance profile (imds) or the ECS/EKS container endpoint (container)")
+	fs.DurationVar(&imdsTimeout, "imds-timeout", imdsTimeout, "how long to wait for IMDS or the container endpoint before giving up")
+	fs.BoolVar(&imdsV1Fallback, "imds-v1-fallback", false, "fall back to IMDSv1 when the IMDSv2 token doesn't arrive, e.g. from a container behind a hop limit of 1")
+	fs.BoolVar(&useFIPS, "fips", false, "use FIPS endpoints for STS and S3")
+	fs.BoolVar(&useDualStack, "dualstack", false, "use dual-stack (IPv6) endpoints for STS and S3")
+	fs.BoolVar(&pathStyle, "path-style", false, "use path-style S3 addressing, for bucket names containing dots")
+	fs.StringVar(&proxyURL, "proxy", "", "proxy URL to send every request through, http(s):// or socks5(h):// (default from HTTP_PROXY/HTTPS_PROXY)")
+	fs.StringVar(&userAgent, "user-agent", "", "User-Agent to send instead of the SDK's default")
+	fs.StringVar(&userAgentAppend, "user-agent-append", "", "text to append to the User-Agent, e.g. an engagement ID")
+	fs.BoolVar(&insecureTLS, "insecure", false, "skip TLS certificate verification, e.g. behind a corporate TLS-intercepting proxy")
+	fs.StringVar(&s3EndpointURL, "s3-endpoint", "", "S3 endpoint URL to use instead of AWS, e.g. http://localhost:4566 for LocalStack")
+	fs.StringVar(&stsEndpoint, "sts-endpoint", "", "STS endpoint URL to use instead of the SDK's, e.g. a private VPC endpoint")
+	fs.StringVar(&stsRegion, "sts-region", stsRegion, "STS endpoint for AssumeRole: bucket (the bucket's region once known), fastest (lowest latency) or a region name")
+	fs.Func("assume-role-region", "region whose STS endpoint the AssumeRole calls go to, same as -sts-region <region>", func(s string) error {
+		if s == "bucket" || s == "fastest" || s == "" {
+			return fmt.Errorf("expected a region name, use -sts-region for %q", s)
+		}
+		stsRegion = s
+		return nil
+	})
+	fs.Func("audit-log", "file every AWS request is appended to as a JSON line (operation, parameters, request ID, HTTP status, time), as evidence of what was run", openAuditLog)
+	fs.StringVar(&knowledgeBaseFile, "knowledge-base", knowledgeBaseFile, "file confirmed bucket owners are recorded in across runs (empty to disable)")
+	fs.BoolVar(&forceSearch, "force", false, "search for owners already in the knowledge base again")
+	fs.BoolVar(&probeCanaries, "probe-canaries", false, "probe buckets that look like canaries anyway, with a warning")
+	fs.StringVar(&regionCacheFile, "region-cache", regionCacheFile, "file bucket regions are cached in across runs (empty to disable)")
+	fs.Float64Var(&requestRate, "rate", 0, "maximum AWS requests per second across all probes (0 for unlimited)")
+	fs.IntVar(&maxAttempts, "max-attempts", 0, "maximum attempts per AWS request, including retries (0 for the SDK default)")
+	fs.StringVar(&retryMode, "retry-mode", retryMode, "SDK retry mode: standard or adaptive (client-side throttling)")
+	fs.DurationVar(&requestTimeout, "request-timeout", 0, "give up on a single probe after this long, e.g. 30s (0 for no limit)")
+	fs.Int64Var(&maxAPICalls, "max-api-calls", 0, "stop once this many AWS requests have been made, reporting partial results (0 for unlimited)")
+	fs.DurationVar(&overallTimeout, "timeout", 0, "give up on the whole run after this long, e.g. 2h (0 for no limit)")
+}
+
+// Returns the root context for a run, bounded by -timeout if given and cancelled by
+// Ctrl-C or SIGTERM, so that an interrupted run still reports and checkpoints what it
+// found. A second Ctrl-C kills the process as usual.
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if overallTimeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, overallTimeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// Loads the AWS configuration for -profile with the retry and rate limit settings applied
+func loadConfig(ctx context.Context) aws.Config {
+	if (accessKey == "") != (secretKey == "") {
+		fatalConfigf("access-key and secret-key must be given together")
+	}
+	if accessKey != "" && profile != "" {
+		fatalConfigf("profile and access-key can't be used together")
+	}
+	if webIdentityTokenFile != "" && (accessKey != "" || profile != "") {
+		fatalConfigf("web-identity-token-file can't be used with profile or access-key")
+	}
+	if err := validateCredentialSource(); err != nil {
+		fatalConfigf("%v", err)
+	}
+	if credentialSource != "" && (accessKey != "" || profile != "" || webIdentityTokenFile != "") {
+		fatalConfigf("credential-source can't be used with profile, access-key or web-identity-token-file")
+	}
+	if webIdentityTokenFile != "" && webIdentityRoleArn == "" {
+		fatalConfigf("web-identity-token-file needs web-identity-role-arn (or AWS_ROLE_ARN)")
+	}
+	if sessionDuration != 0 && (sessionDuration < 900 || sessionDuration > 43200) {
+		fatalConfigf("duration-seconds must be between 900 and 43200")
+	}
+	if randomSessionName != "" && randomSessionName != "run" && randomSessionName != "probe" {
+		fatalConfigf("unknown random-session-name %q, expected run or probe", randomSessionName)
+	}
+	if proxyURL != "" {
+		if _, err := parseProxyURL(proxyURL); err != nil {
+			fatalConfigf("invalid proxy: %v", err)
+		}
+	}
+	if insecureTLS {
+		slog.Warn("TLS certificate verification is disabled (-insecure)")
+	}
+	cfg, err := newConfig(ctx, profile)
+	if err != nil {
+		fatalConfigf("failed to load AWS configuration: %v", err)
+	}
+	if stsRegion != "bucket" && stsRegion != "fastest" && !isDefaultSTSRegion(stsRegion) {
+		slog.Warn("STS isn't enabled in the region by default; AssumeRole there fails unless the account has enabled it", "region", stsRegion)
+	}
+	return cfg
+}
+
+// Static base credentials, set from flags. Profiles, including per-target ones, take
+// precedence over them.
+var (
+	accessKey    string
+	secretKey    string
+	sessionToken string
+)
+
+// OIDC token file and the role it's exchanged for with AssumeRoleWithWebIdentity to get
+// the base credentials, set from flags
+var (
+	webIdentityTokenFile string
+	webIdentityRoleArn   = os.Getenv("AWS_ROLE_ARN")
+)
+
+// Shared config and credentials files to read instead of ~/.aws/config and
+// ~/.aws/credentials, set from flags
+var (
+	awsConfigFile      string
+	awsCredentialsFile string
+)
+
+// Returns the load options pointing the SDK at -aws-config and -aws-credentials
+func sharedConfigFiles() []func(*config.LoadOptions) error {
+	var opts []func(*config.LoadOptions) error
+	if awsConfigFile != "" {
+		opts = append(opts, config.WithSharedConfigFiles([]string{awsConfigFile}))
+	}
+	if awsCredentialsFile != "" {
+		opts = append(opts, config.WithSharedCredentialsFiles([]string{awsCredentialsFile}))
+	}
+	return opts
+}
+
+// Shared config profile the base credentials come from, set from flags ("" for the
+// default chain, including AWS_PROFILE)
+var profile string
+
+// Configurations for batch targets with their own profile
+var profileConfigs = newSharedCache[aws.Config]()
+
+// Returns the configuration for a batch target's profile, loading it on first use
+func configForProfile(ctx context.Context, cfg aws.Config, name string) (aws.Config, error) {
+	if name == "" {
+		return cfg, nil
+	}
+	if c, ok := profileConfigs.get(name); ok {
+		return c, nil
+	}
+	c, err := newConfig(ctx, name)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load profile %s: %w", name, err)
+	}
+	profileConfigs.set(name, c)
+	return c, nil
+}
+
+// Loads the AWS configuration for the profile with the retry and rate limit settings applied
+func newConfig(ctx context.Context, profile string) (aws.Config, error) {
+	mode, err := aws.ParseRetryMode(retryMode)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("invalid retry-mode: %w", err)
+	}
+
+	opts := []func(*config.LoadOptions) error{
+		config.WithRetryer(func() aws.Retryer {
+			return newRetryer(mode)
+		}),
+	}
+	if useFIPS {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if useDualStack {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	opts = append(opts, sharedConfigFiles()...)
+	if customHTTPClient() {
+		opts = append(opts, config.WithHTTPClient(sdkHTTPClient()))
+	}
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	} else if accessKey != "" {
+		opts = append(opts, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, sessionToken)))
+	}
+	if accessKey == "" && webIdentityTokenFile == "" && credentialSource == "" {
+		if err := ensureSSOLogin(ctx, profile); err != nil {
+			return aws.Config{}, err
+		}
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	// Applied before any credential client is built from cfg, so those calls are
+	// limited, charged, counted and logged like the rest
+	applyMiddlewares(&cfg)
+	if partitionName == "" {
+		partitionName = partitionForRegion(cfg.Region)
+	}
+	if cfg.Region == "" {
+		cfg.Region = currentPartition().defaultRegion
+	}
+	if profile == "" && webIdentityTokenFile != "" {
+		// The provider rereads the token file on every refresh, so rotated tokens are picked up
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(
+			sts.NewFromConfig(cfg, withSTSEndpoint), webIdentityRoleArn, stscreds.IdentityTokenFile(webIdentityTokenFile)))
+	}
+	if profile == "" && credentialSource != "" {
+		cfg.Credentials = aws.NewCredentialsCache(newInstanceCredentials(cfg))
+	}
+	if cfg.Credentials != nil {
+		cfg.Credentials = newReloadableCredentials(cfg.Credentials, profile)
+	}
+	if mfaSerial != "" {
+		cfg.Credentials = newMFASessionCredentials(cfg)
+	}
+	return cfg, nil
+}
+
+// Adds the User-Agent, rate limit, call budget, throttle counter and audit log
+// middlewares to every client built from the config
+func applyMiddlewares(cfg *aws.Config) {
+	applyUserAgent(cfg)
+	applyRateLimit(cfg)
+	applyCallBudget(cfg)
+	applyThrottleCounter(cfg)
+	applyAuditLog(cfg)
+}
+
+// Builds the retryer for the mode. The client-side retry quota is disabled because
+// under sustained throttling it runs dry and turns retryable errors into failures.
+func newRetryer(mode aws.RetryMode) aws.Retryer {
+	standard := func(o *retry.StandardOptions) {
+		if maxAttempts > 0 {
+			o.MaxAttempts = maxAttempts
+		}
+		o.RateLimiter = ratelimit.None
+		o.Retryables = append(o.Retryables, retry.RetryableErrorCode{Codes: throttleErrorCodes})
+	}
+	if mode == aws.RetryModeAdaptive {
+		return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standard)
+		})
+	}
+	return retry.NewStandard(standard)
+}
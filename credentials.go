@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+// Error codes meaning the base credentials or an assumed session have lapsed
+var expiredCredentialCodes = map[string]struct{}{
+	"ExpiredToken":          {},
+	"ExpiredTokenException": {},
+	"RequestExpired":        {},
+	"TokenRefreshRequired":  {},
+}
+
+// The base credentials, reloadable from the environment and shared config files so that
+// long runs survive credentials being refreshed underneath them (e.g. by aws sso login
+// or a credential helper rewriting ~/.aws/credentials)
+type reloadableCredentials struct {
+	mu         sync.Mutex
+	profile    string // Shared config profile they were loaded from ("" for the default chain)
+	provider   aws.CredentialsProvider
+	cache      *aws.CredentialsCache
+	lastReload time.Time
+}
+
+// Base credentials that can be reloaded after an expiry error
+type credentialsReloader interface {
+	reload(ctx context.Context) error
+}
+
+// The base credentials of each loaded config, by the cache clients use
+var (
+	baseCredentials   = make(map[*aws.CredentialsCache]credentialsReloader)
+	baseCredentialsMu sync.Mutex
+)
+
+// Wraps the configured credentials so they can be reloaded, returning the cache that
+// clients should use
+func newReloadableCredentials(provider aws.CredentialsProvider, profile string) *aws.CredentialsCache {
+	c := &reloadableCredentials{profile: profile, provider: provider}
+	c.cache = aws.NewCredentialsCache(c)
+	registerBaseCredentials(c.cache, c)
+	return c.cache
+}
+
+// Lets refreshCredentials reload the credentials behind the cache
+func registerBaseCredentials(cache *aws.CredentialsCache, c credentialsReloader) {
+	baseCredentialsMu.Lock()
+	defer baseCredentialsMu.Unlock()
+	baseCredentials[cache] = c
+}
+
+// Retrieves credentials from the current provider
+func (c *reloadableCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	c.mu.Lock()
+	provider := c.provider
+	c.mu.Unlock()
+	return provider.Retrieve(ctx)
+}
+
+// Reloads the credentials from the default sources. Concurrent probes tend to find
+// the credentials expired together, so a reload within the last few seconds is reused.
+func (c *reloadableCredentials) reload(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.lastReload) < 5*time.Second {
+		return nil
+	}
+	if c.profile == "" && accessKey != "" {
+		return errors.New("static credentials from -access-key can't be reloaded; run again with fresh ones")
+	}
+	if c.profile == "" && (webIdentityTokenFile != "" || credentialSource != "") {
+		// Exchanging the token file again, or asking IMDS or the container endpoint
+		// again, is all a reload needs
+		c.cache.Invalidate()
+		c.lastReload = time.Now()
+		return nil
+	}
+
+	if err := ensureSSOLogin(ctx, c.profile); err != nil {
+		return err
+	}
+	opts := sharedConfigFiles()
+	if customHTTPClient() {
+		opts = append(opts, config.WithHTTPClient(sdkHTTPClient()))
+	}
+	if c.profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(c.profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return err
+	}
+	c.provider = cfg.Credentials
+	c.cache.Invalidate()
+	c.lastReload = time.Now()
+	return nil
+}
+
+// Reports whether the error says the credentials used have expired
+func isExpiredCredentials(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	_, ok := expiredCredentialCodes[apiErr.ErrorCode()]
+	return ok
+}
+
+// Reloads the config's base credentials after an expiry error. Assumed sessions need
+// nothing more, as every probe assumes the role afresh.
+func refreshCredentials(ctx context.Context, cfg aws.Config) error {
+	cache, _ := cfg.Credentials.(*aws.CredentialsCache)
+	baseCredentialsMu.Lock()
+	c := baseCredentials[cache]
+	baseCredentialsMu.Unlock()
+	if c == nil {
+		return errors.New("credentials can't be reloaded")
+	}
+	return c.reload(ctx)
+}
+
+// MFA device and, optionally, the first token code, set from flags
+var (
+	mfaSerial string
+	mfaToken  string
+)
+
+// Temporary base credentials from GetSessionToken with an MFA code. Every probe assumes
+// the role afresh, and an MFA code can only be used once, so the MFA check is done once
+// for the session; the session's credentials carry MFA context into every AssumeRole.
+type mfaSessionCredentials struct {
+	client     *sts.Client
+	token      string // Given with -mfa-token, used for the first session only
+	mu         sync.Mutex
+	cache      *aws.CredentialsCache
+	lastReload time.Time
+}
+
+// Wraps the config's credentials in an MFA session, cached until it expires and
+// registered so that an expiry error starts a new session
+func newMFASessionCredentials(cfg aws.Config) *aws.CredentialsCache {
+	c := &mfaSessionCredentials{
+		client: sts.NewFromConfig(cfg, withSTSEndpoint),
+		token:  mfaToken,
+	}
+	c.cache = aws.NewCredentialsCache(c)
+	registerBaseCredentials(c.cache, c)
+	return c.cache
+}
+
+// Drops the expired MFA session so the next probe gets a new one, asking for a fresh
+// code. The credentials the session is got with are reloaded first where they can be,
+// e.g. after the profile's keys were rotated. As with a base reload, one within the
+// last few seconds is reused.
+func (c *mfaSessionCredentials) reload(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.lastReload) < 5*time.Second {
+		return nil
+	}
+	if inner, ok := c.client.Options().Credentials.(*aws.CredentialsCache); ok {
+		baseCredentialsMu.Lock()
+		base := baseCredentials[inner]
+		baseCredentialsMu.Unlock()
+		if base != nil {
+			if err := base.reload(ctx); err != nil {
+				slog.Debug("Failed to reload the credentials for the MFA session, reusing them", "err", err)
+			}
+		}
+	}
+	c.cache.Invalidate()
+	c.lastReload = time.Now()
+	return nil
+}
+
+// Gets a session token with the MFA code, prompting for it on the terminal if -mfa-token
+// wasn't given or has already been used
+func (c *mfaSessionCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	token := c.token
+	c.token = ""
+	if token == "" {
+		fmt.Fprintf(os.Stderr, "MFA token code for %s: ", mfaSerial)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return aws.Credentials{}, fmt.Errorf("failed to read the MFA token code: %w", err)
+		}
+		token = strings.TrimSpace(line)
+	}
+
+	out, err := c.client.GetSessionToken(ctx, &sts.GetSessionTokenInput{
+		SerialNumber:    aws.String(mfaSerial),
+		TokenCode:       aws.String(token),
+		DurationSeconds: aws.Int32(int32(mfaSessionDuration / time.Second)),
+	})
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("failed to get an MFA session: %w", err)
+	}
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(out.Credentials.SessionToken),
+		Source:          "MFA session",
+		CanExpire:       true,
+		Expires:         aws.ToTime(out.Credentials.Expiration),
+	}, nil
+}
+
+// How long an MFA session lasts before the code is asked for again
+const mfaSessionDuration = 12 * time.Hour
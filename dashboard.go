@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mattn/go-runewidth"
+)
+
+// Whether to show the interactive dashboard for batch runs, set from flags
+var useDashboard bool
+
+// Returned for targets skipped from the dashboard
+var errSkipped = errors.New("skipped from the dashboard")
+
+// Throttling responses seen so far, retries included
+var throttleEvents atomic.Int64
+
+// Whether probing is paused from the dashboard, and the channel closed on resuming
+var (
+	pauseMu sync.Mutex
+	resumed chan struct{} // nil while not paused
+)
+
+// Blocks while probing is paused
+func waitWhilePaused(ctx context.Context) error {
+	pauseMu.Lock()
+	ch := resumed
+	pauseMu.Unlock()
+	if ch == nil {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pauses probing, or resumes it, returning whether it's now paused
+func togglePause() bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+	if resumed == nil {
+		resumed = make(chan struct{})
+		return true
+	}
+	close(resumed)
+	resumed = nil
+	return false
+}
+
+// Counts throttling responses for every client built from the config, for the dashboard
+// and -progress-json. It sits after the retry middleware, so each throttled attempt is seen.
+func applyThrottleCounter(cfg *aws.Config) {
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("ThrottleCounter",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				out, md, err := next.HandleFinalize(ctx, in)
+				var apiErr smithy.APIError
+				if errors.As(err, &apiErr) {
+					if _, ok := throttleErrorCodes[apiErr.ErrorCode()]; ok {
+						throttleEvents.Add(1)
+						emitEvent(progressEvent{Event: "throttled", Operation: awsmiddleware.GetOperationName(ctx), Code: apiErr.ErrorCode()})
+						if d := activeDashboard.Load(); d != nil {
+							d.event("Throttled: " + apiErr.ErrorCode())
+						}
+					}
+				}
+				return out, md, err
+			}), middleware.After)
+	})
+}
+
+// A batch target as shown on the dashboard
+type dashboardTarget struct {
+	bucket    string
+	state     string // queued, running, done, failed or skipped
+	prefix    string
+	accountID string
+	status    string
+	err       error
+	started   time.Time
+	elapsed   time.Duration
+	cancel    context.CancelFunc
+}
+
+// Live state of a batch run, updated by the workers and drawn by the dashboard
+type dashboard struct {
+	mu      sync.Mutex
+	targets []*dashboardTarget
+	events  []string // Most recent last
+	start   time.Time
+	cancel  context.CancelFunc // Stops the whole run
+	closed  chan struct{}      // Closed once every target has finished
+}
+
+// The dashboard being shown, nil if none
+var activeDashboard atomic.Pointer[dashboard]
+
+// Creates the dashboard for the buckets, returning it with the context the run should
+// use so that it can be stopped from the dashboard
+func newDashboard(ctx context.Context, buckets []string) (context.Context, *dashboard) {
+	ctx, cancel := context.WithCancel(ctx)
+	d := &dashboard{
+		start:  time.Now(),
+		cancel: cancel,
+		closed: make(chan struct{}),
+	}
+	for _, b := range buckets {
+		t := &dashboardTarget{bucket: b, state: "queued"}
+		d.targets = append(d.targets, t)
+	}
+	activeDashboard.Store(d)
+	return ctx, d
+}
+
+// Marks the search of the i'th target as started, returning the context for it, which
+// skipping the target cancels
+func (d *dashboard) begin(ctx context.Context, i int) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := d.targets[i]
+	if t.state == "skipped" {
+		cancel()
+		return ctx
+	}
+	t.state, t.started, t.cancel = "running", time.Now(), cancel
+	return ctx
+}
+
+// Records the digits found so far for the bucket, on each of its targets running
+func (d *dashboard) progress(bucket, prefix string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.targets {
+		if t.bucket == bucket && t.state == "running" {
+			t.prefix = prefix
+		}
+	}
+}
+
+// Records the outcome of the i'th target, returning errSkipped in place of the error if
+// it was skipped
+func (d *dashboard) finish(i int, accountID, status string, err error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := d.targets[i]
+	bucket := t.bucket
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.accountID, t.status, t.err = accountID, status, err
+	if !t.started.IsZero() {
+		t.elapsed = time.Since(t.started)
+	}
+	switch {
+	case t.state == "skipped":
+		t.err = errSkipped
+		d.eventLocked("Skipped " + bucket)
+	case err != nil:
+		t.state = "failed"
+		d.eventLocked(fmt.Sprintf("Failed %s: %v", bucket, err))
+	default:
+		t.state = "done"
+		d.eventLocked(fmt.Sprintf("Found %s: %s", bucket, accountID))
+	}
+	return t.err
+}
+
+// Skips the target, cancelling its search if it's running
+func (d *dashboard) skip(i int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if i < 0 || i >= len(d.targets) {
+		return
+	}
+	t := d.targets[i]
+	if t.state != "queued" && t.state != "running" {
+		return
+	}
+	t.state = "skipped"
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+// Adds a line to the event log
+func (d *dashboard) event(line string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.eventLocked(line)
+}
+
+func (d *dashboard) eventLocked(line string) {
+	d.events = append(d.events, time.Now().Format("15:04:05")+" "+line)
+	if len(d.events) > 5 {
+		d.events = d.events[len(d.events)-5:]
+	}
+}
+
+// Marks every target as finished, letting the dashboard close
+func (d *dashboard) close() {
+	close(d.closed)
+}
+
+// Shows the dashboard until every target has finished, or the run is stopped and the
+// workers have wound down. Everything else the run prints, logs included, is discarded
+// meanwhile, as it would tear the screen; the results are printed as usual afterwards.
+func (d *dashboard) run() {
+	tty, stdout, stderr := os.Stdout, os.Stdout, os.Stderr
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err == nil {
+		os.Stdout, os.Stderr = devNull, devNull
+	}
+	p := tea.NewProgram(dashboardModel{d: d}, tea.WithOutput(tty), tea.WithAltScreen())
+	if _, err := p.Run(); err != nil {
+		d.cancel()
+	}
+	<-d.closed
+	if devNull != nil {
+		os.Stdout, os.Stderr = stdout, stderr
+		devNull.Close()
+	}
+	activeDashboard.Store(nil)
+	pauseMu.Lock()
+	paused := resumed != nil
+	pauseMu.Unlock()
+	if paused {
+		togglePause()
+	}
+}
+
+// Redraw tick of the dashboard
+type dashboardTick struct{}
+
+func tickDashboard() tea.Cmd {
+	return tea.Tick(250*time.Millisecond, func(time.Time) tea.Msg { return dashboardTick{} })
+}
+
+// The bubbletea model of the dashboard
+type dashboardModel struct {
+	d      *dashboard
+	cursor int
+	height int
+	paused bool
+}
+
+func (m dashboardModel) Init() tea.Cmd {
+	return tickDashboard()
+}
+
+func (m dashboardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j":
+			if m.cursor < len(m.d.targets)-1 {
+				m.cursor++
+			}
+		case "p", " ":
+			m.paused = togglePause()
+			if m.paused {
+				m.d.event("Paused")
+			} else {
+				m.d.event("Resumed")
+			}
+		case "s":
+			m.d.skip(m.cursor)
+		case "q", "ctrl+c":
+			m.d.event("Stopping")
+			m.d.cancel()
+			if m.paused {
+				m.paused = togglePause()
+			}
+		}
+	case dashboardTick:
+		select {
+		case <-m.d.closed:
+			return m, tea.Quit
+		default:
+		}
+		return m, tickDashboard()
+	}
+	return m, nil
+}
+
+func (m dashboardModel) View() string {
+	d := m.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, t := range d.targets {
+		counts[t.state]++
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "S3AccountFinder  %d targets | %d running | %d done | %d failed | %d skipped | throttled %d× | %s",
+		len(d.targets), counts["running"], counts["done"], counts["failed"], counts["skipped"],
+		throttleEvents.Load(), time.Since(d.start).Round(time.Second))
+	if m.paused {
+		b.WriteString("  [PAUSED]")
+	}
+	b.WriteString("\n\n")
+
+	// Keep the selected target in view, leaving room for the header, events and help
+	rows := len(d.targets)
+	if m.height > 0 {
+		rows = max(m.height-12, 3)
+	}
+	first := 0
+	if m.cursor >= rows {
+		first = m.cursor - rows + 1
+	}
+	for i := first; i < len(d.targets) && i < first+rows; i++ {
+		t := d.targets[i]
+		marker := "  "
+		if i == m.cursor {
+			marker = "> "
+		}
+		fmt.Fprintf(&b, "%s%-40s %-8s %s\n", marker, truncate(t.bucket, 40), t.state, t.detail())
+	}
+
+	b.WriteString("\n")
+	for _, e := range d.events {
+		b.WriteString(e + "\n")
+	}
+	b.WriteString("\n↑/↓ select  p pause/resume  s skip  q stop\n")
+	return b.String()
+}
+
+// Describes the target's progress or outcome for its dashboard row
+func (t *dashboardTarget) detail() string {
+	switch t.state {
+	case "running":
+		return fmt.Sprintf("%-12s %d/%d  %s", t.prefix, len(t.prefix), maxDigits, time.Since(t.started).Round(time.Second))
+	case "done":
+		return fmt.Sprintf("%s%s  %s", t.accountID, statusSuffix(t.status), t.elapsed.Round(time.Second))
+	case "failed":
+		if t.accountID != "" {
+			return t.accountID + " (partial) " + truncate(t.err.Error(), 50)
+		}
+		return truncate(t.err.Error(), 60)
+	}
+	return ""
+}
+
+// Shortens s to n terminal columns, never splitting a character and counting wide
+// characters as two
+func truncate(s string, n int) string {
+	return runewidth.Truncate(s, n, "…")
+}
+
+// Reports whether stdin and stdout are both terminals, as the dashboard needs
+func dashboardAvailable() bool {
+	return isTerminal(os.Stdin) && isTerminal(os.Stdout)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Schema of the -db results store. Every run gets a row in runs, and the targets and
+// digits it searched refer to it.
+const resultsSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id         INTEGER PRIMARY KEY,
+	started_at TEXT NOT NULL,
+	args       TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS targets (
+	id           INTEGER PRIMARY KEY,
+	run_id       INTEGER NOT NULL REFERENCES runs(id),
+	path         TEXT,
+	label        TEXT,
+	bucket       TEXT NOT NULL,
+	key          TEXT,
+	region       TEXT,
+	account_id   TEXT,
+	status       TEXT NOT NULL,
+	digits_found INTEGER NOT NULL,
+	duration     REAL NOT NULL,
+	error        TEXT,
+	finished_at  TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS targets_bucket ON targets(bucket);
+CREATE INDEX IF NOT EXISTS targets_account_id ON targets(account_id);
+CREATE TABLE IF NOT EXISTS digits (
+	id       INTEGER PRIMARY KEY,
+	run_id   INTEGER NOT NULL REFERENCES runs(id),
+	bucket   TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	prefix   TEXT NOT NULL,
+	duration REAL NOT NULL,
+	found_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS digits_bucket ON digits(bucket);
+`
+
+// SQLite results store, set from flags (nil when not recording), and this run's row in it
+var (
+	resultsDB *sql.DB
+	dbRunID   int64
+)
+
+// Opens the results store at path, creating the schema if needed, and records the run
+func openResultsDB(path string) error {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return err
+	}
+	// SQLite allows a single writer; one connection serialises the workers' inserts
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(resultsSchema); err != nil {
+		db.Close()
+		return err
+	}
+	res, err := db.Exec("INSERT INTO runs (started_at, args) VALUES (?, ?)",
+		time.Now().UTC().Format(time.RFC3339), strings.Join(redactArgs(os.Args[1:]), " "))
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if dbRunID, err = res.LastInsertId(); err != nil {
+		db.Close()
+		return err
+	}
+	resultsDB = db
+	return nil
+}
+
+// Flags whose values are credentials, kept out of the recorded arguments
+var secretFlags = map[string]bool{
+	"access-key":    true,
+	"secret-key":    true,
+	"session-token": true,
+	"mfa-token":     true,
+	"external-id":   true,
+}
+
+// Returns the command line arguments with the values of secret flags masked, as the
+// results store tends to be shared
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	for i := 0; i < len(redacted) && redacted[i] != "--"; i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(redacted[i], "-"), "=")
+		if !strings.HasPrefix(redacted[i], "-") || !secretFlags[name] {
+			continue
+		}
+		if hasValue {
+			redacted[i] = redacted[i][:strings.Index(redacted[i], "=")+1] + "REDACTED"
+		} else if i+1 < len(redacted) {
+			i++
+			redacted[i] = "REDACTED"
+		}
+	}
+	return redacted
+}
+
+// Records a target's result. Failing to record doesn't stop the run.
+func recordResult(r runResult) {
+	if resultsDB == nil {
+		return
+	}
+	_, err := resultsDB.Exec(`INSERT INTO targets
+		(run_id, path, label, bucket, key, region, account_id, status, digits_found, duration, error, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		dbRunID, r.Path, r.Label, r.Bucket, r.Key, r.Region, r.AccountID, r.Status, r.DigitsFound, r.Duration, r.Error,
+		time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		slog.Error("Failed to record the result in the results database", "bucket", r.Bucket, "err", err)
+	}
+}
+
+// Records a digit found, with the prefix it completes and how long finding it took
+func recordDigit(bucket, prefix string, elapsed time.Duration) {
+	if resultsDB == nil {
+		return
+	}
+	_, err := resultsDB.Exec("INSERT INTO digits (run_id, bucket, position, prefix, duration, found_at) VALUES (?, ?, ?, ?, ?, ?)",
+		dbRunID, bucket, len(prefix), prefix, elapsed.Seconds(), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		slog.Error("Failed to record a digit in the results database", "bucket", bucket, "err", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		args, want []string
+	}{
+		{
+			[]string{"-path", "bucket", "-role_arn", "arn:aws:iam::111122223333:role/r"},
+			[]string{"-path", "bucket", "-role_arn", "arn:aws:iam::111122223333:role/r"},
+		},
+		{
+			[]string{"-access-key", "AKIA", "-secret-key", "s3cr3t", "-path", "bucket"},
+			[]string{"-access-key", "REDACTED", "-secret-key", "REDACTED", "-path", "bucket"},
+		},
+		{
+			[]string{"--session-token=tok", "-mfa-token=123456", "-external-id", "ext"},
+			[]string{"--session-token=REDACTED", "-mfa-token=REDACTED", "-external-id", "REDACTED"},
+		},
+		{
+			[]string{"-secret-key"},
+			[]string{"-secret-key"},
+		},
+		{
+			[]string{"-secret-key=", "-access-key-file", "k", "secret-key", "s"},
+			[]string{"-secret-key=REDACTED", "-access-key-file", "k", "secret-key", "s"},
+		},
+		{
+			[]string{"--", "-secret-key", "s"},
+			[]string{"--", "-secret-key", "s"},
+		},
+	}
+	for _, tt := range tests {
+		if got := redactArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("redactArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
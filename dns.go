@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Follows a hostname's CNAME chain and, if it ends at an S3 endpoint, returns the
+// bucket named by that endpoint. The region in the endpoint is cached as well.
+// Hostnames served by CloudFront are inspected for an S3 origin instead.
+// Anything that isn't a hostname or doesn't point at S3 is returned unchanged.
+func resolveBucketHost(bucket string) string {
+	if !strings.Contains(bucket, ".") || arn.IsARN(bucket) || isMultiRegionAccessPoint(bucket) {
+		return bucket
+	}
+	if isCloudFrontHost(bucket) {
+		return resolveCloudFrontOrigin(bucket)
+	}
+	cname, err := net.LookupCNAME(bucket)
+	if err != nil {
+		return bucket
+	}
+	cname = strings.ToLower(strings.TrimSuffix(cname, "."))
+	if isCloudFrontHost(cname) {
+		return resolveCloudFrontOrigin(bucket)
+	}
+	if cname == bucket || !isS3Host(cname) {
+		return bucket
+	}
+
+	resolved, _ := urlToS3Args(cname)
+	if resolved == "" {
+		// Path-style endpoints don't name a bucket; website buckets are named after the hostname
+		resolved = bucket
+	}
+	if region, ok := s3HostRegion(cname); ok {
+		bucketRegions.set(resolved, region)
+	}
+	slog.Info("Name is a CNAME, using the bucket it points to", "name", bucket, "cname", cname, "bucket", resolved)
+	return resolved
+}
+
+// S3 endpoint labels that are followed by a separate region label rather than ending in one
+var s3EndpointVariants = map[string]bool{
+	"s3-website": true, "s3-fips": true, "s3-accesspoint": true, "s3-object-lambda": true, "s3-control": true,
+}
+
+// Returns the region named in an S3 endpoint hostname, e.g. bucket.s3-website-us-east-1.amazonaws.com,
+// bucket.s3-website.eu-west-1.amazonaws.com or bucket.s3.dualstack.us-west-2.amazonaws.com
+func s3HostRegion(host string) (string, bool) {
+	labels := strings.Split(trimS3Domain(host), ".")
+	idx := s3LabelIndex(labels)
+	if idx < 0 {
+		return "", false
+	}
+
+	switch label := labels[idx]; {
+	case label == "s3-external-1":
+		return "us-east-1", true
+	case strings.HasPrefix(label, "s3-website-"):
+		return strings.TrimPrefix(label, "s3-website-"), true
+	case label == "s3-global":
+		return "", false
+	case strings.HasPrefix(label, "s3-") && !s3EndpointVariants[label]:
+		return strings.TrimPrefix(label, "s3-"), true
+	}
+
+	// The region is the next label that isn't an endpoint variant
+	for _, label := range labels[idx+1:] {
+		if label != "dualstack" && label != "fips" {
+			return label, true
+		}
+	}
+	// Legacy global endpoint
+	return "us-east-1", true
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+// Session durations tried, longest first, to find the role's maximum
+var sessionDurations = []time.Duration{12 * time.Hour, 6 * time.Hour, 4 * time.Hour, 2 * time.Hour, time.Hour}
+
+// Checks the setup before a real run: the base credentials, assuming the role, its
+// maximum session duration, whether session policies are honoured and whether the
+// role has the S3 permissions the probe needs. Exits with exitConfigError if any check fails.
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	roleArn := fs.String("role_arn", "", "ARN of the role to assume")
+	path := fs.String("path", "", "s3 bucket or bucket/path to check the probe permissions against")
+	knownBucket := fs.String("known-bucket", "", "bucket whose owner is known, to check that session policies are honoured")
+	knownOwner := fs.String("known-owner", "", "account ID owning -known-bucket (default: the role's account)")
+	fs.StringVar(&probeOperation, "probe", "", "S3 operation to test access with: head-object, get-object, get-object-attributes, list-objects or head-bucket (default: chosen per target)")
+	addRequestFlags(fs)
+	fs.BoolVar(&noColor, "no-color", false, "don't colour the output, even on a terminal")
+	addLogFlags(fs)
+	parseFlags(fs, args)
+	initLogging()
+
+	if *roleArn == "" {
+		fatalConfigf("usage: doctor -role_arn <role_arn> [-path <path>] [-known-bucket <bucket> [-known-owner <account_id>]]")
+	}
+	if probeOperation != "" && probeOperations[probeOperation] == nil {
+		fatalConfigf("unknown probe %q, expected head-object, get-object, get-object-attributes, list-objects or head-bucket", probeOperation)
+	}
+	role, err := arn.Parse(*roleArn)
+	if err != nil {
+		fatalConfigf("invalid role_arn: %v", err)
+	}
+	if *knownOwner == "" {
+		*knownOwner = role.AccountID
+	}
+	if len(*knownOwner) != 12 || validateKnownDigits(*knownOwner) != nil {
+		fatalConfigf("%q is not a 12 digit account ID", *knownOwner)
+	}
+
+	setPartitionFromRole(*roleArn)
+	ctx, cancel := runContext()
+	defer cancel()
+	cfg := loadConfig(ctx)
+
+	d := &doctor{}
+	d.checkBaseCredentials(ctx, cfg)
+	if d.checkAssumeRole(ctx, cfg, *roleArn) {
+		d.checkSessionDuration(ctx, cfg, *roleArn)
+		if *knownBucket != "" {
+			d.checkSessionPolicies(ctx, cfg, *roleArn, *knownBucket, *knownOwner)
+		} else {
+			d.skip("session policies", "give -known-bucket to check that session policies are honoured")
+		}
+		if *path != "" {
+			d.checkProbePermissions(ctx, cfg, *roleArn, *path)
+		} else {
+			d.skip("probe permissions", "give -path to check the role's S3 permissions on a target")
+		}
+	}
+
+	if d.failed {
+		os.Exit(exitConfigError)
+	}
+	fmt.Println("All checks passed")
+}
+
+// Collects the results of the doctor checks
+type doctor struct {
+	failed bool
+}
+
+// Reports a passing check
+func (d *doctor) pass(check, format string, args ...interface{}) {
+	fmt.Printf("%s   %s: %s\n", paint(os.Stdout, colorGreen, "[ok]"), check, fmt.Sprintf(format, args...))
+}
+
+// Reports a failing check with what to do about it
+func (d *doctor) fail(check string, err error, remedy string) {
+	d.failed = true
+	fmt.Printf("%s %s: %v\n       fix: %s\n", paint(os.Stdout, colorRed, "[FAIL]"), check, err, remedy)
+}
+
+// Reports a check that wasn't run
+func (d *doctor) skip(check, reason string) {
+	fmt.Printf("%s %s: %s\n", paint(os.Stdout, colorYellow, "[skip]"), check, reason)
+}
+
+// Checks that the base credentials work by asking STS who they belong to
+func (d *doctor) checkBaseCredentials(ctx context.Context, cfg aws.Config) {
+	out, err := stsClientFor(cfg, "").GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		d.fail("base credentials", err, "configure credentials with aws configure, set AWS_PROFILE, or run aws sso login for SSO profiles")
+		return
+	}
+	d.pass("base credentials", "%s", aws.ToString(out.Arn))
+}
+
+// Checks that the role can be assumed without a session policy
+func (d *doctor) checkAssumeRole(ctx context.Context, cfg aws.Config, roleArn string) bool {
+	_, err := newAssumeRoleProvider(stsClientFor(cfg, ""), roleArn, externalID).Retrieve(ctx)
+	if err != nil {
+		d.fail("assume role", err, "allow your identity in the role's trust policy and grant it sts:AssumeRole on "+roleArn+" (see the policy subcommand), and give -external-id if the trust policy requires one")
+		return false
+	}
+	d.pass("assume role", "%s", roleArn)
+	return true
+}
+
+// Finds the role's maximum session duration by assuming it for the longest duration
+// allowed, which works without iam:GetRole
+func (d *doctor) checkSessionDuration(ctx context.Context, cfg aws.Config, roleArn string) {
+	for _, duration := range sessionDurations {
+		_, err := newAssumeRoleProvider(stsClientFor(cfg, ""), roleArn, externalID, func(o *stscreds.AssumeRoleOptions) {
+			o.Duration = duration
+		}).Retrieve(ctx)
+		var apiErr smithy.APIError
+		if err == nil {
+			d.pass("max session duration", "at least %v", duration)
+			return
+		} else if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "ValidationError" {
+			d.fail("max session duration", err, "check that the role can still be assumed")
+			return
+		}
+	}
+	d.fail("max session duration", errors.New("the role can't be assumed for an hour"),
+		"role chaining limits sessions to an hour; assume the role directly from your base credentials")
+}
+
+// Checks that a session policy allowing only the known owner grants access to its
+// bucket and that one allowing only another account doesn't
+func (d *doctor) checkSessionPolicies(ctx context.Context, cfg aws.Config, roleArn, bucket, owner string) {
+	allowed, err := canAccessWithPolicy(ctx, cfg, bucket, "", roleArn, getExactPolicy(bucket, []string{owner}))
+	if err != nil {
+		d.fail("session policies", err, "check that the role can access "+bucket)
+		return
+	}
+	if !allowed {
+		d.fail("session policies", fmt.Errorf("a session policy allowing %s was denied access to %s", owner, bucket),
+			"check that "+owner+" owns "+bucket+" and that the role has s3:ListBucket on it")
+		return
+	}
+
+	// Any account but the owner
+	other := owner[:11] + string('0'+(owner[11]-'0'+1)%10)
+	allowed, err = canAccessWithPolicy(ctx, cfg, bucket, "", roleArn, getExactPolicy(bucket, []string{other}))
+	if err != nil {
+		d.fail("session policies", err, "check that the role can access "+bucket)
+		return
+	}
+	if allowed {
+		d.fail("session policies", fmt.Errorf("a session policy allowing only %s was still allowed access to %s", other, bucket),
+			"the bucket policy grants the role directly, which session policies don't restrict; use a bucket that only grants access through IAM")
+		return
+	}
+	d.pass("session policies", "honoured on %s", bucket)
+}
+
+// Checks that the role can access the target with the chosen probe, or with one of the fallbacks
+func (d *doctor) checkProbePermissions(ctx context.Context, cfg aws.Config, roleArn, path string) {
+	bucket, key, err := parseTarget(path)
+	if err != nil {
+		d.fail("probe permissions", err, "give a bucket, bucket/path, URL or S3 ARN")
+		return
+	}
+	if _, err := checkAccess(ctx, cfg, bucket, key, roleArn); err != nil {
+		d.fail("probe permissions", err, "grant the role s3:GetObject or s3:ListBucket on the target, or pick an allowed operation with -probe")
+		return
+	}
+	op := probeFor(bucket)
+	if op == "" {
+		op = "the default probe"
+	}
+	d.pass("probe permissions", "%s can access %s with %s", roleArn, bucket, op)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	smithyauth "github.com/aws/smithy-go/auth"
+	smithyendpoints "github.com/aws/smithy-go/endpoints"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Routes bare Multi-Region Access Point aliases to the global access point endpoint.
+// The SDK only recognises MRAPs given as ARNs, and building an ARN needs the very
+// account ID we're searching for, so requests for an alias are pointed at the
+// partition's <alias>.accesspoint.s3-global.<suffix> and signed with SigV4A for all
+// regions. With -s3-endpoint they go to that endpoint like any other bucket.
+type mrapAliasEndpointResolver struct {
+	s3.EndpointResolverV2
+}
+
+func (r *mrapAliasEndpointResolver) ResolveEndpoint(ctx context.Context, params s3.EndpointParameters) (smithyendpoints.Endpoint, error) {
+	if params.Bucket == nil || !isMRAPAlias(*params.Bucket) || params.Endpoint != nil {
+		return r.EndpointResolverV2.ResolveEndpoint(ctx, params)
+	}
+
+	uri, err := url.Parse("https://" + *params.Bucket + ".accesspoint.s3-global." + currentPartition().dnsSuffix)
+	if err != nil {
+		return smithyendpoints.Endpoint{}, err
+	}
+
+	// Mirrors the properties the SDK's own MRAP endpoint rules attach
+	var signerProps smithy.Properties
+	smithyhttp.SetDisableDoubleEncoding(&signerProps, true)
+	smithyhttp.SetSigV4SigningName(&signerProps, "s3")
+	smithyhttp.SetSigV4ASigningName(&signerProps, "s3")
+	smithyhttp.SetSigV4ASigningRegions(&signerProps, []string{"*"})
+
+	var props smithy.Properties
+	smithyauth.SetAuthOptions(&props, []*smithyauth.Option{
+		{SchemeID: smithyauth.SchemeIDSigV4A, SignerProperties: signerProps},
+	})
+	return smithyendpoints.Endpoint{URI: *uri, Properties: props}, nil
+}
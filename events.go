@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A progress event of -progress-json, written as one JSON object per line
+type progressEvent struct {
+	Time      string  `json:"time"`
+	Event     string  `json:"event"` // target_started, digit_found, probe_failed, throttled or target_finished
+	Bucket    string  `json:"bucket,omitempty"`
+	Prefix    string  `json:"prefix,omitempty"`
+	Position  int     `json:"position,omitempty"`
+	AccountID string  `json:"account_id,omitempty"`
+	Status    string  `json:"status,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	Operation string  `json:"operation,omitempty"`
+	Code      string  `json:"code,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Where progress events go, set from flags (nil when not emitting them)
+var (
+	eventsMu  sync.Mutex
+	eventsOut io.Writer
+)
+
+// Opens the -progress-json destination: fd:N for an inherited file descriptor, stderr,
+// or a file, which is replaced. A wrapper can pass a pipe as fd 3 to keep the events
+// apart from both the results and the human output.
+func openProgressEvents(dest string) error {
+	switch {
+	case dest == "stderr":
+		eventsOut = stderrWriter{}
+	case strings.HasPrefix(dest, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(dest, "fd:"))
+		if err != nil || fd < 0 {
+			return fmt.Errorf("invalid file descriptor %q", dest)
+		}
+		if fd == 1 {
+			return fmt.Errorf("fd:1 is stdout, which the results go to")
+		}
+		f := os.NewFile(uintptr(fd), dest)
+		if _, err := f.Stat(); err != nil {
+			return fmt.Errorf("file descriptor %d isn't open: %w", fd, err)
+		}
+		eventsOut = f
+	default:
+		f, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+		eventsOut = f
+	}
+	return nil
+}
+
+// Writes the event, stamped with the time, if events are on. Each is written whole in a
+// single write so that a reader never sees half a line.
+func emitEvent(e progressEvent) {
+	if eventsOut == nil {
+		return
+	}
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	eventsOut.Write(append(b, '\n'))
+}
+
+// Emits the target_finished event for a result
+func emitTargetFinished(r runResult) {
+	emitEvent(progressEvent{
+		Event:     "target_finished",
+		Bucket:    r.Bucket,
+		AccountID: r.AccountID,
+		Status:    r.Status,
+		Duration:  r.Duration,
+		Error:     r.Error,
+	})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Directory the evidence bundle is written to, set from flags ("" for none)
+var evidenceDir string
+
+// A probe as recorded in a target's probes.jsonl
+type evidenceProbe struct {
+	Seq        int    `json:"seq"`
+	Time       string `json:"time"`
+	Step       string `json:"step"` // access, same-org, digit, known-account, confirm or cross-check
+	Position   int    `json:"position,omitempty"`
+	Role       string `json:"role,omitempty"`
+	Policy     string `json:"policy"`
+	Allowed    bool   `json:"allowed"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+	RequestID  string `json:"request_id,omitempty"`
+	HostID     string `json:"host_id,omitempty"`
+	Response   string `json:"response,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// The evidence gathered for a bucket so far
+type evidenceTarget struct {
+	mu     sync.Mutex
+	dir    string
+	probes []evidenceProbe
+}
+
+var (
+	evidenceMu      sync.Mutex
+	evidenceTargets = make(map[string]*evidenceTarget)
+)
+
+// Characters not kept in the directory name of a bucket
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Returns the bucket's evidence, creating its directory on first use
+func evidenceFor(bucket string) (*evidenceTarget, error) {
+	evidenceMu.Lock()
+	defer evidenceMu.Unlock()
+	if t, ok := evidenceTargets[bucket]; ok {
+		return t, nil
+	}
+	t := &evidenceTarget{dir: filepath.Join(evidenceDir, unsafePathChars.ReplaceAllString(bucket, "_"))}
+	for _, sub := range []string{"policies", "responses"} {
+		if err := os.MkdirAll(filepath.Join(t.dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	evidenceTargets[bucket] = t
+	return t, nil
+}
+
+// Key of the search step a probe belongs to
+type evidenceStepKey struct{}
+
+type evidenceStep struct {
+	name     string
+	position int
+}
+
+// Marks the probes made with the context as belonging to the step, and for digits the
+// position being searched
+func withEvidenceStep(ctx context.Context, name string, position int) context.Context {
+	if evidenceDir == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, evidenceStepKey{}, evidenceStep{name, position})
+}
+
+// The last HTTP response to a probe, kept for the bundle
+type capturedResponse struct {
+	mu   sync.Mutex
+	dump []byte
+	resp *http.Response
+}
+
+// Returns the option capturing the raw response to a probe. Error bodies are kept, as
+// they hold S3's reason for a denial; object data never is.
+func captureResponse(c *capturedResponse) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("CaptureEvidence",
+				func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+					out, md, err := next.HandleDeserialize(ctx, in)
+					if resp, ok := out.RawResponse.(*smithyhttp.Response); ok {
+						c.capture(resp)
+					}
+					return out, md, err
+				}), middleware.After)
+		})
+	}
+}
+
+// Records the response's status line and headers, and its body if it's an error
+func (c *capturedResponse) capture(resp *smithyhttp.Response) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\n", resp.Proto, resp.Status)
+	resp.Header.Write(&b)
+	if resp.StatusCode >= 300 && resp.Body != nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		// Put the body back for the SDK to deserialise
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		b.WriteString("\n")
+		b.Write(body)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dump, c.resp = b.Bytes(), resp.Response
+}
+
+// Records a probe in the bucket's evidence: the session policy it was made with, the
+// response and the outcome. Failing to record doesn't stop the run.
+func recordEvidence(ctx context.Context, bucket, roleArn string, policy map[string]interface{}, allowed bool, probeErr error, c *capturedResponse) {
+	t, err := evidenceFor(bucket)
+	if err != nil {
+		slog.Error("Failed to create the evidence directory", "bucket", bucket, "err", err)
+		return
+	}
+	step, ok := ctx.Value(evidenceStepKey{}).(evidenceStep)
+	if !ok {
+		step.name = "access"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := evidenceProbe{
+		Seq:      len(t.probes) + 1,
+		Time:     time.Now().UTC().Format(time.RFC3339Nano),
+		Step:     step.name,
+		Position: step.position,
+		Role:     roleArn,
+		Policy:   fmt.Sprintf("policies/%04d.json", len(t.probes)+1),
+		Allowed:  allowed,
+	}
+	if probeErr != nil {
+		p.Error = probeErr.Error()
+	}
+	policyJSON, _ := json.MarshalIndent(policy, "", "  ")
+	if err := os.WriteFile(filepath.Join(t.dir, p.Policy), append(policyJSON, '\n'), 0o600); err != nil {
+		slog.Error("Failed to save evidence", "bucket", bucket, "err", err)
+	}
+	c.mu.Lock()
+	if c.resp != nil {
+		p.HTTPStatus = c.resp.StatusCode
+		p.RequestID = c.resp.Header.Get("x-amz-request-id")
+		p.HostID = c.resp.Header.Get("x-amz-id-2")
+		p.Response = fmt.Sprintf("responses/%04d.txt", p.Seq)
+		if err := os.WriteFile(filepath.Join(t.dir, p.Response), c.dump, 0o600); err != nil {
+			slog.Error("Failed to save evidence", "bucket", bucket, "err", err)
+		}
+	}
+	c.mu.Unlock()
+
+	t.probes = append(t.probes, p)
+	line, _ := json.Marshal(p)
+	f, err := os.OpenFile(filepath.Join(t.dir, "probes.jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		slog.Error("Failed to save evidence", "bucket", bucket, "err", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// A digit of the account ID in the summary, with the probes that found it
+type evidenceDigit struct {
+	Position   int      `json:"position"`
+	Digit      string   `json:"digit"`
+	Prefix     string   `json:"prefix"`
+	Probes     []int    `json:"probes"`
+	Allowed    []int    `json:"allowed_probes"`
+	RequestIDs []string `json:"request_ids"`
+}
+
+// The summary.json of a bucket's evidence
+type evidenceSummary struct {
+	Bucket       string          `json:"bucket"`
+	Key          string          `json:"key,omitempty"`
+	Region       string          `json:"region,omitempty"`
+	AccountID    string          `json:"account_id,omitempty"`
+	Status       string          `json:"status"`
+	Caller       string          `json:"caller,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Strategy     string          `json:"strategy"`
+	Generated    string          `json:"generated"`
+	Digits       []evidenceDigit `json:"digits"`
+	Verification []evidenceProbe `json:"verification,omitempty"` // Same-organization, known-account, confirmation and cross-check probes
+	TotalProbes  int             `json:"total_probes"`
+}
+
+// Writes the bucket's summary.json once its search has finished, mapping each digit
+// position to the probes, policies and request IDs that substantiate it
+func writeEvidenceSummary(r runResult) {
+	if evidenceDir == "" {
+		return
+	}
+	evidenceMu.Lock()
+	t, ok := evidenceTargets[r.Bucket]
+	evidenceMu.Unlock()
+	if !ok {
+		return // Nothing was probed, e.g. the owner was already known
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := evidenceSummary{
+		Bucket:      r.Bucket,
+		Key:         r.Key,
+		Region:      r.Region,
+		AccountID:   r.AccountID,
+		Status:      r.Status,
+		Caller:      r.CallerARN,
+		Error:       r.Error,
+		Strategy:    searchStrategy,
+		Generated:   time.Now().UTC().Format(time.RFC3339),
+		TotalProbes: len(t.probes),
+	}
+	byPosition := make(map[int]*evidenceDigit)
+	for i, d := range r.AccountID {
+		byPosition[i+1] = &evidenceDigit{Position: i + 1, Digit: string(d), Prefix: r.AccountID[:i+1]}
+	}
+	for _, p := range t.probes {
+		switch p.Step {
+		case "digit":
+			d, ok := byPosition[p.Position]
+			if !ok {
+				continue // A position that was never resolved
+			}
+			d.Probes = append(d.Probes, p.Seq)
+			if p.Allowed {
+				d.Allowed = append(d.Allowed, p.Seq)
+			}
+			if p.RequestID != "" {
+				d.RequestIDs = append(d.RequestIDs, p.RequestID)
+			}
+		case "same-org", "known-account", "confirm", "cross-check":
+			s.Verification = append(s.Verification, p)
+		}
+	}
+	for _, d := range byPosition {
+		s.Digits = append(s.Digits, *d)
+	}
+	sort.Slice(s.Digits, func(i, j int) bool { return s.Digits[i].Position < s.Digits[j].Position })
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(t.dir, "summary.json"), append(b, '\n'), 0o600)
+	}
+	if err != nil {
+		slog.Error("Failed to save the evidence summary", "bucket", r.Bucket, "err", err)
+	}
+}
+
+// Reports whether the evidence directory can be written to, creating it if needed
+func checkEvidenceDir() error {
+	if err := os.MkdirAll(evidenceDir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(evidenceDir, ".write-test-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
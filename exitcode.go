@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/aws/smithy-go"
+)
+
+// Exit statuses of the main command and the subcommands, for CI jobs and wrappers to branch on
+const (
+	exitFound       = 0 // The account ID, or the prefix asked for with -max-digits
+	exitNoAccess    = 1 // The target couldn't be accessed, or no digit could be found
+	exitPartial     = 2 // Some digits were found, but not all
+	exitConfigError = 3 // Bad flags, targets or AWS configuration
+	exitAborted     = 4 // Throttled, out of API call budget, timed out, interrupted or refused as a canary
+)
+
+// Logs the message and exits with exitConfigError
+func fatalConfigf(format string, v ...interface{}) {
+	slog.Error(fmt.Sprintf(format, v...))
+	discardStructuredOutput()
+	os.Exit(exitConfigError)
+}
+
+// Parses a command's flags, which must be set to continue on error. Bad flags exit with
+// exitConfigError rather than the flag package's 2, which means a partial result here.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(exitConfigError)
+	}
+}
+
+// Reports whether the error means the run was cut short rather than the target
+// answering: throttling that outlasted the retries, the call budget, -timeout, an
+// interrupt or a suspected canary that wasn't probed
+func isAborted(err error) bool {
+	if budgetExhausted() || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || errors.Is(err, errCanary) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		_, ok := throttleErrorCodes[apiErr.ErrorCode()]
+		return ok
+	}
+	return false
+}
+
+// Returns the exit status for a search that found accountID and stopped with err
+func exitCodeFor(accountID string, err error) int {
+	switch {
+	case err != nil && isAborted(err):
+		return exitAborted
+	case len(accountID) >= maxDigits && err == nil:
+		return exitFound
+	case accountID != "":
+		return exitPartial
+	}
+	return exitNoAccess
+}
+
+// Returns the more telling of two exit statuses, for a batch run: an aborted run
+// outranks failures, which outrank partial results
+func worseExitCode(a, b int) int {
+	rank := map[int]int{exitFound: 0, exitPartial: 1, exitNoAccess: 2, exitConfigError: 3, exitAborted: 4}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
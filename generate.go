@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mutations used when no wordlist is given
+var defaultMutations = []string{
+	"assets", "static", "media", "images", "img", "cdn", "web", "www", "public", "private",
+	"uploads", "files", "data", "backup", "backups", "archive", "logs", "internal",
+	"dev", "test", "staging", "stage", "qa", "prod", "production",
+}
+
+// Valid S3 bucket names: 3-63 lowercase letters, digits, dots and hyphens, starting and ending alphanumerically
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// Number of concurrent existence checks
+const existenceWorkers = 10
+
+// Generates candidate bucket names from keywords, keeps the ones that exist and enumerates their owners
+func runGenerate(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	keywords := fs.String("keyword", "", "keyword or company name to build bucket names from (comma separated for several)")
+	wordlist := fs.String("wordlist", "", "file of mutation words, one per line (defaults to a built-in list)")
+	roleArn := fs.String("role_arn", "", "ARN of the role to assume; if omitted, existing buckets are only listed")
+	addRequestFlags(fs)
+	addLogFlags(fs)
+	parseFlags(fs, args)
+	initLogging()
+
+	if *keywords == "" {
+		fatalConfigf("keyword is required")
+	}
+
+	mutations := defaultMutations
+	if *wordlist != "" {
+		var err error
+		mutations, err = readWordlist(*wordlist)
+		if err != nil {
+			fatalConfigf("failed to read wordlist: %v", err)
+		}
+	}
+
+	candidates := generateBucketNames(strings.Split(*keywords, ","), mutations)
+	slog.Info("Checking candidate bucket names", "count", len(candidates))
+
+	var targets []target
+	for _, bucket := range filterExistingBuckets(candidates) {
+		fmt.Printf("exists: %s\n", bucket)
+		targets = append(targets, target{Path: bucket})
+	}
+	if budgetExhausted() {
+		slog.Error("API call budget exhausted before every candidate was checked")
+		os.Exit(exitAborted)
+	}
+
+	runFoundTargets(targets, *roleArn)
+}
+
+// Builds the de-duplicated, valid bucket name permutations of each keyword and mutation
+func generateBucketNames(keywords, mutations []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if !seen[name] && bucketNameRegexp.MatchString(name) && !strings.Contains(name, "..") {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, keyword := range keywords {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword == "" {
+			continue
+		}
+		add(keyword)
+		for _, m := range mutations {
+			for _, sep := range []string{"-", ".", ""} {
+				add(keyword + sep + m)
+				add(m + sep + keyword)
+			}
+		}
+	}
+	return names
+}
+
+// Returns the candidates that exist, checked concurrently
+func filterExistingBuckets(candidates []string) []string {
+	exists := make([]bool, len(candidates))
+	runPool(context.Background(), existenceWorkers, len(candidates), func(ctx context.Context, i int) {
+		exists[i] = bucketExists(ctx, candidates[i])
+	})
+
+	var found []string
+	for i, bucket := range candidates {
+		if exists[i] {
+			found = append(found, bucket)
+		}
+	}
+	return found
+}
+
+// Client for the existence checks, created on first use so that it picks up -proxy
+var bucketExistsClient = sync.OnceValue(func() *http.Client {
+	return &http.Client{Transport: newHTTPTransport()}
+})
+
+// Checks whether a bucket exists with an unauthenticated path-style HEAD request.
+// Anything other than a 404 (403, 301 to another region, 200) means the name is taken.
+func bucketExists(ctx context.Context, bucket string) bool {
+	if err := waitForRate(ctx); err != nil {
+		return false
+	}
+	if err := chargeAPICall(); err != nil {
+		return false
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s3BaseURL()+"/"+bucket, nil)
+	if err != nil {
+		return false
+	}
+	start := time.Now()
+	resp, err := bucketExistsClient().Do(req)
+	auditHTTP("S3", "HeadBucket", req, resp, start, err)
+	if err != nil {
+		slog.Error("Failed to check bucket", "bucket", bucket, "err", err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode != http.StatusNotFound
+}
+
+// Reads one word per line, skipping blank lines and # comments
+func readWordlist(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words, scanner.Err()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"gopkg.in/yaml.v3"
+)
+
+// Keys whose string values name a bucket, by source format
+var ingestBucketKeys = map[string]map[string]bool{
+	// Terraform state: aws_s3_* resources, logging blocks and data sources all use "bucket"
+	"terraform": {"bucket": true},
+	// CloudFormation/SAM: AWS::S3::Bucket properties, Lambda code locations and so on
+	"cfn": {"BucketName": true, "S3Bucket": true, "Bucket": true},
+}
+
+// Extracts bucket references from Terraform state or CloudFormation templates and enumerates their owners
+func runIngest(args []string) {
+	if len(args) == 0 || ingestBucketKeys[args[0]] == nil {
+		fatalConfigf("usage: ingest terraform|cfn [-role_arn <role_arn>] <file>...")
+	}
+	kind := args[0]
+
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	roleArn := fs.String("role_arn", "", "ARN of the role to assume; if omitted, found targets are only listed")
+	addRequestFlags(fs)
+	addLogFlags(fs)
+	parseFlags(fs, args[1:])
+	initLogging()
+
+	if fs.NArg() == 0 {
+		fatalConfigf("at least one file is required")
+	}
+
+	var targets []target
+	seen := make(map[string]bool)
+	for _, filename := range fs.Args() {
+		paths, err := extractBucketReferences(filename, ingestBucketKeys[kind])
+		if err != nil {
+			fatalConfigf("failed to read %s: %v", filename, err)
+		}
+		for _, path := range paths {
+			// The same bucket often shows up both by name and by ARN
+			bucket, key, _ := toS3Args(path)
+			if !seen[bucket+"/"+key] {
+				seen[bucket+"/"+key] = true
+				fmt.Printf("found: %s\n", path)
+				targets = append(targets, target{Path: path})
+			}
+		}
+	}
+
+	runFoundTargets(targets, *roleArn)
+}
+
+// Walks a JSON or YAML document collecting bucket names under the given keys,
+// plus any s3:// URIs, S3 ARNs and S3 URLs found in string values
+func extractBucketReferences(filename string, bucketKeys map[string]bool) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is valid YAML, and decoding to nodes tolerates CloudFormation's !Ref/!Sub tags
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	var walk func(node *yaml.Node, key string)
+	walk = func(node *yaml.Node, key string) {
+		switch node.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, child := range node.Content {
+				walk(child, key)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				walk(node.Content[i+1], node.Content[i].Value)
+			}
+		case yaml.ScalarNode:
+			// Intrinsic functions (!Ref, !Sub, ...) aren't literal bucket names
+			if node.Tag != "!!str" {
+				return
+			}
+			if path, ok := bucketReference(node.Value, bucketKeys[key]); ok {
+				paths = append(paths, path)
+			}
+		}
+	}
+	walk(&doc, "")
+	return paths, nil
+}
+
+// Returns the target path referenced by a string value, if any
+func bucketReference(value string, isBucketKey bool) (string, bool) {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.HasPrefix(value, "s3://"):
+		return value, true
+	case strings.HasPrefix(value, "arn:") && arn.IsARN(value) && strings.Contains(value, ":s3"):
+		if _, _, err := toS3Args(value); err == nil {
+			return value, true
+		}
+	case (strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://")) && isS3Host(hostOf(value[strings.Index(value, "://")+3:])):
+		return value, true
+	case isBucketKey && bucketNameRegexp.MatchString(value):
+		return value, true
+	}
+	return "", false
+}
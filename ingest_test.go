@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestBucketReference(t *testing.T) {
+	tests := []struct {
+		value       string
+		isBucketKey bool
+		want        string
+		ok          bool
+	}{
+		{"s3://bucket/key", false, "s3://bucket/key", true},
+		{"  s3://bucket  ", false, "s3://bucket", true},
+		{"arn:aws:s3:::bucket/key", false, "arn:aws:s3:::bucket/key", true},
+		{"arn:aws:s3:us-west-2:111122223333:accesspoint/ap", false, "arn:aws:s3:us-west-2:111122223333:accesspoint/ap", true},
+		{"arn:aws:s3:us-west-2:111122223333:job/j", false, "", false},
+		{"arn:aws:iam::111122223333:role/s3-reader", false, "", false},
+		{"https://bucket.s3.eu-west-1.amazonaws.com/key", false, "https://bucket.s3.eu-west-1.amazonaws.com/key", true},
+		{"https://example.com/bucket", false, "", false},
+		{"my-bucket", true, "my-bucket", true},
+		{"my-bucket", false, "", false},
+		{"Not A Bucket", true, "", false},
+		{"", true, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := bucketReference(tt.value, tt.isBucketKey)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("bucketReference(%q, %v) = %q, %v, want %q, %v", tt.value, tt.isBucketKey, got, ok, tt.want, tt.ok)
+		}
+	}
+}
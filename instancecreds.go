@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// Where the base credentials must come from, set from flags: "imds" for the EC2 instance
+// profile, "container" for the ECS task role or EKS Pod Identity, or "" for the default chain
+var credentialSource string
+
+// IMDS settings, set from flags. The instance's HttpPutResponseHopLimit decides whether
+// the IMDSv2 token reaches a container at all; with the default of 1 the token response
+// is dropped one hop short and the request just times out.
+var (
+	imdsTimeout    = 5 * time.Second
+	imdsV1Fallback bool
+)
+
+// Address of the ECS container credential endpoint for relative URIs
+const ecsCredentialsHost = "http://169.254.170.2"
+
+// Checks that -credential-source can be used here, before anything is requested
+func validateCredentialSource() error {
+	switch credentialSource {
+	case "", "imds":
+		return nil
+	case "container":
+		if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") == "" && os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") == "" {
+			return errors.New("credential-source container needs AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or AWS_CONTAINER_CREDENTIALS_FULL_URI, which ECS and EKS Pod Identity set in the task or pod")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown credential-source %q, expected imds or container", credentialSource)
+}
+
+// Returns the provider for -credential-source
+func newInstanceCredentials(cfg aws.Config) aws.CredentialsProvider {
+	if credentialSource == "container" {
+		return &instanceCredentials{provider: newContainerProvider(cfg)}
+	}
+	client := imds.NewFromConfig(cfg, func(o *imds.Options) {
+		// Ignore AWS_EC2_METADATA_DISABLED, the flag asks for IMDS explicitly
+		o.ClientEnableState = imds.ClientEnabled
+		if imdsV1Fallback {
+			o.EnableFallback = aws.TrueTernary
+		} else {
+			o.EnableFallback = aws.FalseTernary
+		}
+	})
+	return &instanceCredentials{provider: ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+		o.Client = client
+	})}
+}
+
+// Creates the provider for the ECS/EKS container credential endpoint, with the
+// authorization token if there is one. The token file is reread on every refresh, as
+// EKS Pod Identity rotates it.
+func newContainerProvider(cfg aws.Config) *endpointcreds.Provider {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		endpoint = ecsCredentialsHost + rel
+	}
+	return endpointcreds.New(endpoint, func(o *endpointcreds.Options) {
+		o.HTTPClient = cfg.HTTPClient
+		if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+			o.AuthorizationTokenProvider = endpointcreds.TokenProviderFunc(func() (string, error) {
+				b, err := os.ReadFile(tokenFile)
+				return strings.TrimSpace(string(b)), err
+			})
+		} else {
+			o.AuthorizationToken = os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+		}
+	})
+}
+
+// Credentials from IMDS or the container endpoint, retrieved within -imds-timeout and
+// with failures explained, as the SDK's errors say little about why
+type instanceCredentials struct {
+	provider aws.CredentialsProvider
+}
+
+// Retrieves the credentials, failing after -imds-timeout rather than hanging
+func (c *instanceCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+	creds, err := c.provider.Retrieve(ctx)
+	if err == nil {
+		return creds, nil
+	}
+	if credentialSource == "container" {
+		return aws.Credentials{}, fmt.Errorf("no credentials from the container credential endpoint (is a task role or pod identity association set up?): %w", err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) && !imdsV1Fallback {
+		return aws.Credentials{}, fmt.Errorf("no answer from IMDS within %v; from a container the instance's HttpPutResponseHopLimit must be at least 2 for the IMDSv2 token to arrive, or retry with -imds-v1-fallback: %w", imdsTimeout, err)
+	}
+	return aws.Credentials{}, fmt.Errorf("no credentials from IMDS (is an instance profile attached, and IMDS enabled?): %w", err)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A confirmed bucket owner, as kept in the knowledge base
+type ownerRecord struct {
+	AccountID string    `json:"account_id"`
+	Method    string    `json:"method"`
+	FoundAt   time.Time `json:"found_at"`
+}
+
+// File confirmed bucket owners are kept in across runs, set from flags ("" disables it)
+var knowledgeBaseFile = defaultKnowledgeBaseFile()
+
+// Whether to enumerate buckets already in the knowledge base, set from flags
+var forceSearch bool
+
+var (
+	knowledgeBase     map[string]ownerRecord // Bucket to its confirmed owner
+	knowledgeBaseOnce sync.Once
+	knowledgeBaseMu   sync.Mutex
+)
+
+// Returns ~/.s3accountfinder/accounts.json, or "" if there's no home directory
+func defaultKnowledgeBaseFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".s3accountfinder", "accounts.json")
+}
+
+// Loads the knowledge base. A missing file just means nothing is known yet.
+func loadKnowledgeBase() {
+	knowledgeBase = make(map[string]ownerRecord)
+	if knowledgeBaseFile == "" {
+		return
+	}
+	data, err := os.ReadFile(knowledgeBaseFile)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &knowledgeBase); err != nil {
+		slog.Warn("Ignoring unreadable knowledge base", "file", knowledgeBaseFile, "err", err)
+		knowledgeBase = make(map[string]ownerRecord)
+	}
+}
+
+// Returns the owner recorded for a bucket by a previous run, unless -force was given
+func knownOwner(bucket string) (ownerRecord, bool) {
+	if forceSearch {
+		return ownerRecord{}, false
+	}
+	knowledgeBaseOnce.Do(loadKnowledgeBase)
+	knowledgeBaseMu.Lock()
+	defer knowledgeBaseMu.Unlock()
+	record, ok := knowledgeBase[bucket]
+	return record, ok
+}
+
+// Records a confirmed bucket owner and how it was found
+func recordOwner(bucket, accountID, method string) {
+	if knowledgeBaseFile == "" {
+		return
+	}
+	knowledgeBaseOnce.Do(loadKnowledgeBase)
+	knowledgeBaseMu.Lock()
+	defer knowledgeBaseMu.Unlock()
+	knowledgeBase[bucket] = ownerRecord{AccountID: accountID, Method: method, FoundAt: time.Now().UTC()}
+
+	data, err := json.MarshalIndent(knowledgeBase, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(knowledgeBaseFile), 0o700)
+	}
+	if err == nil {
+		err = os.WriteFile(knowledgeBaseFile, data, 0o600)
+	}
+	if err != nil {
+		slog.Error("Failed to save knowledge base", "err", err)
+	}
+}
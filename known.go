@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// An account ID from a known-accounts dataset, with an optional name for reporting
+type knownAccount struct {
+	ID   string
+	Name string
+}
+
+// Accounts checked while enumerating, set from flags (nil disables the short-circuit)
+var knownAccounts []knownAccount
+
+// Reads a known-accounts file: one account ID per line, optionally followed by a
+// name after whitespace or a comma. Blank lines and # comments are ignored.
+func readKnownAccounts(filename string) ([]knownAccount, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseKnownAccounts(f)
+}
+
+// Parses known accounts in the format of readKnownAccounts
+func parseKnownAccounts(r io.Reader) ([]knownAccount, error) {
+	var accounts []knownAccount
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, name := strings.Replace(line, ",", " ", 1), ""
+		if i := strings.IndexFunc(id, unicode.IsSpace); i >= 0 {
+			id, name = id[:i], id[i:]
+		}
+		if len(id) != 12 || validateKnownDigits(id) != nil {
+			return nil, fmt.Errorf("line %d: %q is not a 12 digit account ID", n, id)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		accounts = append(accounts, knownAccount{ID: id, Name: strings.TrimSpace(name)})
+	}
+	return accounts, scanner.Err()
+}
+
+// Returns the known accounts whose ID starts with prefix
+func matchKnownAccounts(prefix string) []knownAccount {
+	var matches []knownAccount
+	for _, account := range knownAccounts {
+		if strings.HasPrefix(account.ID, prefix) {
+			matches = append(matches, account)
+		}
+	}
+	return matches
+}
+
+// Returns the name a known account is reported under
+func (a knownAccount) name() string {
+	if a.Name != "" {
+		return a.ID + " (" + a.Name + ")"
+	}
+	return a.ID
+}
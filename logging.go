@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+)
+
+// Log settings, set from flags
+var (
+	logLevel  = "info"
+	logFormat = "text"
+)
+
+// Registers the logging flags, shared by the main command and the subcommands
+func addLogFlags(fs *flag.FlagSet) {
+	fs.StringVar(&logLevel, "log-level", logLevel, "least severe log messages shown: debug, info, warn or error")
+	fs.StringVar(&logFormat, "log-format", logFormat, "log format on stderr: text, or json for one object per line")
+}
+
+// Writes to whatever os.Stderr is at the time, so that logs follow it when it's
+// swapped out, as the dashboard does
+type stderrWriter struct{}
+
+func (stderrWriter) Write(p []byte) (int, error) {
+	return os.Stderr.Write(p)
+}
+
+// Sets up the default logger from the flags, exiting on bad values. The log package
+// writes through it too.
+func initLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		fatalConfigf("unknown log-level %q, expected debug, info, warn or error", logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(stderrWriter{}, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(stderrWriter{}, opts)))
+	default:
+		fatalConfigf("unknown log-format %q, expected text or json", logFormat)
+	}
+}
@@ -6,219 +6,533 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"strings"
-	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 )
 
-var bucketRegionCache sync.Map // Cache for storing bucket regions
+// Object keys tried when discovering a probe key, set from flags (nil disables discovery)
+var probeKeys []string
+
+// Keys commonly present in buckets, tried by -discover-key. A key that doesn't exist
+// gives the same 403 as a denied request when the role can't list the bucket, so
+// discovery needs a key that is actually there.
+var defaultProbeKeys = []string{
+	"index.html", "index.htm", "robots.txt", "favicon.ico", "error.html", "404.html",
+	"sitemap.xml", "crossdomain.xml", "manifest.json", "README.md", "readme.txt", ".well-known/security.txt",
+}
 
 func main() {
-	roleArn := flag.String("role_arn", "", "ARN of the role to assume")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "generate":
+			runGenerate(os.Args[2:])
+			return
+		case "ingest":
+			runIngest(os.Args[2:])
+			return
+		case "scrape":
+			runScrape(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		case "policy":
+			runPolicy(os.Args[2:])
+			return
+		case "report":
+			runReport(os.Args[2:])
+			return
+		}
+	}
+
+	roleArn := flag.String("role_arn", "", "ARN of the role to assume, or a comma-separated list of roles to take turns")
 	path := flag.String("path", "", "s3 bucket or bucket/path to test with")
-	flag.Parse()
+	targets := flag.String("targets", "", "file (or s3:// object) of s3 buckets or bucket/paths to test, one per line, or a .yaml/.yml targets file")
+	discoverKeys := flag.Bool("discover-key", false, "if the bucket itself can't be accessed, look for a common object key to probe with instead")
+	keyWordlist := flag.String("key-wordlist", "", "file of object keys to try when discovering a probe key (implies -discover-key)")
+	flag.StringVar(&searchStrategy, "strategy", searchStrategy, "digit search strategy: sequential (one probe at a time), parallel (all ten digits at once) or bisect (fewest calls)")
+	flag.IntVar(&digitWorkers, "digit-workers", digitWorkers, "number of digits probed at once by the parallel strategy")
+	flag.IntVar(&targetWorkers, "target-workers", targetWorkers, "number of batch targets enumerated at once")
+	flag.IntVar(&confirmations, "confirm", confirmations, "number of StringEquals probes confirming the complete account ID (0 to skip)")
+	flag.BoolVar(&crossCheck, "cross-check", false, "cross-check the complete account ID by probing with ExpectedBucketOwner set to it")
+	flag.IntVar(&maxDigits, "max-digits", maxDigits, "stop after this many digits and report the partial prefix")
+	bucketRegion := flag.String("bucket-region", "", "region the bucket is in, skipping region discovery (the default for -targets entries without a region)")
+	knownDigits := flag.String("known-digits", "", "leading digits of the account ID already known, e.g. from an interrupted run; the search starts after them")
+	knownAccountsFile := flag.String("known-accounts", "", "file of known account IDs (one per line, optionally followed by a name); the search stops as soon as the prefix matches exactly one")
+	flag.BoolVar(&orgLookup, "org-lookup", false, "look the account found up with organizations:DescribeAccount and report its name and email if it's in your organization")
+	flag.BoolVar(&sameOrgCheck, "same-org", false, "before searching, check with a single aws:ResourceOrgID probe whether the bucket is in your organization")
+	flag.StringVar(&sameOrgID, "org-id", "", "organization ID for -same-org, e.g. o-a1b2c3d4e5 (default: looked up with organizations:DescribeOrganization)")
+	flag.Func("vendors", "file of known account owners (account ID then name, one per line) added to the built-in dataset used to name the owner of an account found", loadVendors)
+	checkpointPath := flag.String("checkpoint", "", "file to save progress to after every digit and target, for -resume")
+	resumePath := flag.String("resume", "", "checkpoint file of an interrupted run to pick up from (and keep saving to, unless -checkpoint is given)")
+	flag.StringVar(&probeOperation, "probe", "", "S3 operation to test access with: head-object, get-object, get-object-attributes, list-objects or head-bucket (default: chosen per target)")
+	flag.StringVar(&outputFormat, "format", outputFormat, "result format: text, json (single path), jsonl (targets), csv, grep or template; all but text move progress to stderr")
+	flag.Func("output-template", "Go template each result is printed with, e.g. '{{.Bucket}},{{.AccountID}}' (implies -format template)", parseOutputTemplate)
+	flag.StringVar(&evidenceDir, "evidence", "", "directory to save an evidence bundle in: per target, every session policy, the raw S3 responses and the request IDs behind each digit")
+	progressJSON := flag.String("progress-json", "", "emit progress events as JSON lines to a file, fd:N (e.g. fd:3) or stderr, for wrappers tracking the run")
+	dbPath := flag.String("db", "", "SQLite database to record every run's targets, per-digit timings, account IDs and errors in")
+	outputPath := flag.String("output", "", "file to write the results to, leaving progress on stdout (format defaults to csv for .csv files, or else JSON lines)")
+	appendOutput := flag.Bool("append", false, "append to the output file instead of replacing it")
+	flag.BoolVar(&noColor, "no-color", false, "don't colour the output, even on a terminal (it's never coloured when piped, or with NO_COLOR set)")
+	flag.BoolVar(&useDashboard, "tui", false, "show an interactive dashboard of the batch run, with keys to pause and skip targets (targets only)")
+	flag.BoolVar(&collectStats, "stats", false, "print how long each digit position took and how many probes it used, per position and per target")
+	flag.BoolVar(&showSummary, "summary", showSummary, "print a summary at the end of a batch run: targets, accounts found, failures by reason, API calls and elapsed time")
+	flag.BoolVar(&showProgress, "progress", showProgress, "show a progress bar with an ETA while searching, when stderr is a terminal")
+	flag.BoolVar(&quiet, "quiet", false, "print only the account ID on stdout, everything else on stderr (single path only)")
+	flag.BoolFunc("json", "same as -format json", func(string) error {
+		outputFormat = "json"
+		return nil
+	})
+	flag.BoolFunc("jsonl", "same as -format jsonl", func(string) error {
+		outputFormat = "jsonl"
+		return nil
+	})
+	flag.BoolVar(&rawKeys, "raw-key", false, "use object keys exactly as given, without percent-decoding or treating + in URLs as a space")
+	addRequestFlags(flag.CommandLine)
+	addLogFlags(flag.CommandLine)
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	parseFlags(flag.CommandLine, os.Args[1:])
+	initLogging()
+
+	if *path == "" && *targets == "" {
+		fatalConfigf("either path or targets is required")
+	}
+	if *roleArn == "" && *targets == "" && !federation {
+		fatalConfigf("role_arn is required, unless -federation is given")
+	}
+	if *outputPath != "" && outputFormat == "text" && !quiet {
+		outputFormat = fileFormat(*outputPath, *targets != "")
+	}
+	switch {
+	case outputFormat == "template" && outputTemplate == nil:
+		fatalConfigf("format template needs output-template")
+	case outputFormat != "text" && outputFormat != "json" && outputFormat != "jsonl" && outputFormat != "csv" && outputFormat != "grep" && outputFormat != "template":
+		fatalConfigf("unknown format %q, expected text, json, jsonl, csv, grep or template", outputFormat)
+	case outputFormat == "json" && *targets != "":
+		fatalConfigf("format json only applies to a single path; use jsonl for targets")
+	case outputFormat == "jsonl" && *targets == "":
+		fatalConfigf("format jsonl only applies to targets; use json for a single path")
+	}
+	if quiet && (*targets != "" || outputFormat != "text" || *outputPath != "") {
+		fatalConfigf("quiet only applies to a single path with the text format, printed on stdout")
+	}
+	if *dbPath != "" {
+		if err := openResultsDB(*dbPath); err != nil {
+			fatalConfigf("failed to open results database: %v", err)
+		}
+	}
+	if evidenceDir != "" {
+		if err := checkEvidenceDir(); err != nil {
+			fatalConfigf("can't write evidence to %s: %v", evidenceDir, err)
+		}
+	}
+	if *progressJSON != "" {
+		if err := openProgressEvents(*progressJSON); err != nil {
+			fatalConfigf("failed to open progress-json: %v", err)
+		}
+	}
+	if useDashboard && (*targets == "" || outputFormat != "text" || *outputPath != "" || !dashboardAvailable()) {
+		fatalConfigf("tui needs targets, the text format printed on stdout, and a terminal")
+	}
+	if *appendOutput && *outputPath == "" {
+		fatalConfigf("append needs output")
+	}
+	if outputFormat != "text" || quiet || *outputPath != "" {
+		if err := enableStructuredOutput(*outputPath, *appendOutput); err != nil {
+			fatalConfigf("failed to open output: %v", err)
+		}
+	}
 
-	if *roleArn == "" || *path == "" {
-		log.Fatalf("role_arn and path are required")
+	if sameOrgID != "" && !orgIDPattern.MatchString(sameOrgID) {
+		fatalConfigf("invalid org-id %q, expected o- followed by 10 to 32 lowercase letters or digits", sameOrgID)
+	}
+	if err := validateKnownDigits(*knownDigits); err != nil {
+		fatalConfigf("invalid known-digits: %v", err)
+	}
+	if digitWorkers < 1 || targetWorkers < 1 {
+		fatalConfigf("digit-workers and target-workers must be at least 1")
+	}
+	if maxDigits < 1 || maxDigits > 12 {
+		fatalConfigf("max-digits must be between 1 and 12")
+	}
+	if probeOperation != "" && probeOperations[probeOperation] == nil {
+		fatalConfigf("unknown probe %q, expected head-object, get-object, get-object-attributes, list-objects or head-bucket", probeOperation)
+	}
+	if digitFinders[searchStrategy] == nil {
+		fatalConfigf("unknown strategy %q, expected sequential, parallel or bisect", searchStrategy)
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if *keyWordlist != "" {
+		keys, err := readWordlist(*keyWordlist)
+		if err != nil {
+			fatalConfigf("failed to read key wordlist: %v", err)
+		}
+		probeKeys = keys
+	} else if *discoverKeys {
+		probeKeys = defaultProbeKeys
+	}
+
+	if *knownAccountsFile != "" {
+		accounts, err := readKnownAccounts(*knownAccountsFile)
+		if err != nil {
+			fatalConfigf("failed to read known accounts: %v", err)
+		}
+		knownAccounts = accounts
+		addVendorNames(vendorNames, accounts)
+	}
+
+	if err := initCheckpoint(*checkpointPath, *resumePath); err != nil {
+		fatalConfigf("failed to load checkpoint: %v", err)
+	}
+
+	setPartitionFromRole(*roleArn)
+	ctx, cancel := runContext()
+	defer cancel()
+	cfg := loadConfig(ctx)
+	lookupCallerIdentity(ctx, cfg)
+	initSameOrg(ctx, cfg)
+
+	if *targets != "" {
+		code := runBatch(ctx, cfg, *targets, *roleArn, *knownDigits, *bucketRegion)
+		finishStructuredOutput()
+		os.Exit(code)
+	}
+
+	bucket, key, err := parseTarget(*path)
 	if err != nil {
-		log.Fatalf("failed to load AWS configuration: %v", err)
+		fatalConfigf("invalid path: %v", err)
+	}
+	if *bucketRegion != "" {
+		bucketRegions.set(bucket, *bucketRegion)
 	}
 
-	bucket, key := toS3Args(*path)
+	start := time.Now()
+	emitEvent(progressEvent{Event: "target_started", Bucket: bucket})
+	accountID, status, probeKey, err := findAccountID(withProgress(ctx), cfg, bucket, key, *roleArn, *knownDigits)
+	if err == nil {
+		lookupOrgAccount(ctx, cfg, accountID)
+	}
+	printRunResult(newRunResult(bucket, probeKey, accountID, status, time.Since(start), err))
+	switch {
+	case errors.Is(err, errIncomplete):
+		colorPrintf(os.Stdout, colorYellow, "Bucket owner account ID prefix (partial, could not find all %d digits): %s\n", maxDigits, accountID)
+	case err != nil && accountID != "":
+		// Whatever was found before the error is still worth having
+		slog.Error("Search stopped", "bucket", bucket, "err", err)
+		colorPrintf(os.Stdout, colorYellow, "Bucket owner account ID prefix (partial, search stopped): %s\n", accountID)
+	case err != nil:
+		slog.Error(err.Error(), "bucket", bucket)
+	case len(accountID) == 12:
+		colorPrintf(os.Stdout, statusColor(status), "Bucket owner account ID: %s%s%s\n", accountID, statusSuffix(status), ownerSuffix(accountID))
+	default:
+		colorPrintf(os.Stdout, colorYellow, "Bucket owner account ID prefix (partial): %s\n", accountID)
+	}
+	printStats()
+	finishStructuredOutput()
+	os.Exit(exitCodeFor(accountID, err))
+}
 
-	// Try accessing the bucket without any restrictions
-	if !canAccessWithPolicy(cfg, bucket, key, *roleArn, nil) {
-		fmt.Fprintf(os.Stderr, "%s cannot access %s\n", *roleArn, bucket)
-		os.Exit(1)
+// Checks access and searches for the account ID of a single target, returning it with
+// its confirmation status and the key probed, which may be a discovered one. On error,
+// the digits found before it are returned too.
+func findAccountID(ctx context.Context, cfg aws.Config, bucket, key, roleArn, knownDigits string) (string, string, string, error) {
+	if accountID, ok := completedAccountID(bucket); ok {
+		return accountID, "", key, nil
+	}
+	if record, ok := knownOwner(bucket); ok {
+		slog.Info("Owner already in the knowledge base (use -force to search again)", "bucket", bucket, "method", record.Method, "found", record.FoundAt.Format("2006-01-02"))
+		return record.AccountID, "", key, nil
 	}
+	knownDigits = resumeDigits(bucket, knownDigits)
 
-	fmt.Println("Starting search (this can take a while)")
+	if err := checkCanary(ctx, cfg, bucket); err != nil {
+		return "", "", key, err
+	}
+	key, err := checkAccess(ctx, cfg, bucket, key, roleArn)
+	if err != nil {
+		return "", "", key, err
+	}
+	if err := checkSameOrg(ctx, cfg, bucket, key, roleArn); err != nil {
+		return "", "", key, err
+	}
 
-	accountID := searchAccountID(cfg, bucket, key, *roleArn)
-	if len(accountID) != 12 {
-		log.Fatalf("Could not find all 12 digits of the account ID")
-	} else {
-		fmt.Printf("Bucket owner account ID: %s\n", accountID)
+	stopProgress := func() {}
+	if showsProgress(ctx) {
+		stopProgress = startProgress(knownDigits)
+	}
+	accountID, status, err := searchAccountID(ctx, cfg, bucket, key, roleArn, knownDigits)
+	stopProgress()
+	if err != nil {
+		return accountID, "", key, err
 	}
+	if len(accountID) < maxDigits {
+		return accountID, "", key, fmt.Errorf("%w (%d wanted)", errIncomplete, maxDigits)
+	}
+	if status != statusUnconfirmed {
+		recordCompleted(bucket, accountID)
+	}
+	return accountID, status, key, nil
 }
 
-// Performs a binary search to find the account ID
-func searchAccountID(cfg aws.Config, bucket, key, roleArn string) string {
-	accountID := ""
-	for len(accountID) < 12 {
-		nextDigit := findNextDigitConcurrently(cfg, bucket, key, roleArn, accountID)
-		if nextDigit == "" {
-			log.Fatalf("Could not find the next digit for account ID")
+// Tries accessing the target without any restrictions, returning the key to probe with.
+// With several roles, every one of them has to have access, as any of them may make
+// any probe.
+func checkAccess(ctx context.Context, cfg aws.Config, bucket, key, roleArn string) (string, error) {
+	key, err := findAccess(ctx, cfg, bucket, key, roleArn)
+	roles := splitRoles(roleArn)
+	if err != nil || len(roles) < 2 {
+		return key, err
+	}
+	for _, role := range roles {
+		allowed, err := canAccessWithPolicy(ctx, cfg, bucket, key, role, nil)
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			return "", fmt.Errorf("%s %w %s, but every role given needs the same access", role, errNoAccess, bucket)
 		}
-		accountID += nextDigit
-		fmt.Printf("Found digits so far: %s\n", accountID)
 	}
-	return accountID
+	return key, nil
 }
 
-// Finds the next digit concurrently using goroutines
-func findNextDigitConcurrently(cfg aws.Config, bucket, key, roleArn, prefix string) string {
-	possibleDigits := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
-	ch := make(chan string, len(possibleDigits))
-
-	for _, digit := range possibleDigits {
-		go func(digit string) {
-			testPrefix := prefix + digit
-			policy := getPolicy([]string{testPrefix + "*"})
-			if canAccessWithPolicy(cfg, bucket, key, roleArn, policy) {
-				ch <- digit
-			} else {
-				ch <- ""
-			}
-		}(digit)
+// Tries accessing the target without any restrictions, returning the key to probe with.
+// When only a bucket is given and it can't be accessed, the probe keys are tried in
+// turn in case the role has object-level access only.
+func findAccess(ctx context.Context, cfg aws.Config, bucket, key, roleArn string) (string, error) {
+	if key == "" && probeNeedsKey() && len(probeKeys) == 0 {
+		return "", fmt.Errorf("-probe %s needs an object key; give one in the path or use -discover-key", probeOperation)
 	}
-
-	for range possibleDigits {
-		if nextDigit := <-ch; nextDigit != "" {
-			return nextDigit
+	if !(key == "" && probeNeedsKey()) {
+		allowed, err := canAccessWithPolicy(ctx, cfg, bucket, key, roleArn, nil)
+		if err != nil && !skipInconclusive(err, "the default probe") {
+			return "", err
+		}
+		if allowed {
+			return key, nil
+		}
+	}
+	if !isOutpostsBucketARN(bucket) {
+		op, ok, err := fallbackProbe(ctx, cfg, bucket, key, roleArn)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			slog.Warn("Cannot access the bucket with the default probe, probing with another operation", "role", roleArn, "bucket", bucket, "probe", op)
+			return key, nil
 		}
 	}
+	if key != "" || len(probeKeys) == 0 {
+		return "", fmt.Errorf("%s %w %s", roleArn, errNoAccess, bucket)
+	}
 
-	return ""
+	for _, candidate := range probeKeys {
+		allowed, err := canAccessWithPolicy(ctx, cfg, bucket, candidate, roleArn, nil)
+		if err != nil && !skipInconclusive(err, "probe key "+candidate) {
+			return "", err
+		}
+		if allowed {
+			slog.Warn("Cannot access the bucket directly, probing with a key instead", "role", roleArn, "bucket", bucket, "key", candidate)
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s %w %s or any of %d probe keys", roleArn, errNoAccess, bucket, len(probeKeys))
 }
 
 // Constructs the policy to check for the account ID prefixes
-func getPolicy(prefixes []string) map[string]interface{} {
-	return map[string]interface{}{
-		"Version": "2012-10-17",
-		"Statement": []map[string]interface{}{
-			{
-				"Sid":      "AllowResourceAccount",
-				"Effect":   "Allow",
-				"Action":   "s3:*",
-				"Resource": "*",
-				"Condition": map[string]interface{}{
-					"StringLike": map[string]interface{}{
-						"s3:ResourceAccount": prefixes,
-					},
-				},
+func getPolicy(bucket string, prefixes []string) map[string]interface{} {
+	return resourceAccountPolicy(bucket, "StringLike", prefixes)
+}
+
+// Constructs the policy to check for exact account IDs
+func getExactPolicy(bucket string, accountIDs []string) map[string]interface{} {
+	return resourceAccountPolicy(bucket, "StringEquals", accountIDs)
+}
+
+// Constructs a policy allowing access to resources whose owning account matches the
+// values under the given condition operator
+func resourceAccountPolicy(bucket, operator string, values []string) map[string]interface{} {
+	conditionKey := "s3:ResourceAccount"
+	if isOutposts(bucket) || isDirectoryBucket(bucket) || isObjectLambda(bucket) {
+		// Outposts, directory buckets and Object Lambda have no s3:ResourceAccount key
+		conditionKey = "aws:ResourceAccount"
+	}
+	return sessionPolicy(map[string]interface{}{
+		"Sid":      "AllowResourceAccount",
+		"Effect":   "Allow",
+		"Action":   probeActions(bucket),
+		"Resource": policyResources(bucket),
+		"Condition": map[string]interface{}{
+			operator: map[string]interface{}{
+				conditionKey: values,
 			},
 		},
+	})
+}
+
+// Assumes the role and applies the test policy to check access. An error means the
+// probe gave no answer either way. If the credentials have expired, they're refreshed
+// and the probe is tried once more.
+func canAccessWithPolicy(ctx context.Context, cfg aws.Config, bucket, key, roleArn string, policy map[string]interface{}, optFns ...func(*s3.Options)) (bool, error) {
+	allowed, err := probeWithPolicy(ctx, cfg, bucket, key, roleArn, policy, optFns...)
+	if isExpiredCredentials(err) {
+		slog.Info("Credentials expired, refreshing them and retrying the probe")
+		if refreshErr := refreshCredentials(ctx, cfg); refreshErr != nil {
+			return false, fmt.Errorf("credentials expired and could not be refreshed: %v: %w", refreshErr, err)
+		}
+		allowed, err = probeWithPolicy(ctx, cfg, bucket, key, roleArn, policy, optFns...)
 	}
+	return allowed, err
 }
 
-// Assumes the role and applies the test policy to check access
-func canAccessWithPolicy(cfg aws.Config, bucket, key, roleArn string, policy map[string]interface{}) bool {
-	ctx := context.TODO()
+// Makes a single access check with a freshly assumed session, applying optFns to the probe
+func probeWithPolicy(ctx context.Context, cfg aws.Config, bucket, key, roleArn string, policy map[string]interface{}, optFns ...func(*s3.Options)) (bool, error) {
+	if requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+	}
 
-	// Assume the role using stscreds
-	stsSvc := sts.NewFromConfig(cfg)
-	creds := stscreds.NewAssumeRoleProvider(stsSvc, roleArn, func(opt *stscreds.AssumeRoleOptions) {
-		if policy != nil {
-			policyString := marshalPolicy(policy)
-			opt.Policy = aws.String(policyString)
-		}
-	})
+	if policy == nil {
+		policy = unrestrictedPolicy(bucket)
+	}
+	if err := waitWhilePaused(ctx); err != nil {
+		return false, err
+	}
+	probesIssued.Add(1)
+	countProbeStat(bucket)
+	var captured *capturedResponse
+	if evidenceDir != "" {
+		captured = &capturedResponse{}
+		optFns = append(optFns[:len(optFns):len(optFns)], captureResponse(captured))
+	}
 
-	// Check bucket region cache before querying
-	bucketRegion, found := bucketRegionCache.Load(bucket)
-	if !found {
-		// Create S3 client with assumed role credentials and default region
-		s3Svc := s3.NewFromConfig(cfg, func(o *s3.Options) {
-			o.Credentials = aws.NewCredentialsCache(creds)
-			o.Region = "us-east-1" // Default region for S3
-		})
-
-		// Get the bucket region
-		region, err := manager.GetBucketRegion(ctx, s3Svc, bucket)
-		if err != nil {
-			log.Fatalf("Failed to get bucket region: %v", err)
-		}
-		bucketRegionCache.Store(bucket, region)
-		bucketRegion = region
+	// Assume the role (or get a federated session) restricted by the policy
+	stsSvc := newSTSClient(ctx, cfg, bucket)
+	creds := newSessionCredentials(ctx, stsSvc, roleArn, policy)
+
+	bucketRegion, err := getBucketRegion(ctx, cfg, creds, bucket)
+	if err != nil {
+		return false, err
 	}
 
-	// Create a new S3 client with the correct region
-	s3Svc := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.Credentials = aws.NewCredentialsCache(creds)
-		o.Region = bucketRegion.(string)
-	})
+	// Probe with the shared client for the bucket's region, signed with this policy's credentials
+	err = probeBucket(ctx, cfg, s3ClientFor(cfg, bucketRegion, bucket), creds, bucket, key, optFns...)
+	if region, ok := redirectRegion(err); ok && region != bucketRegion {
+		// The region lookup was wrong, so correct the cache and probe again in the right one
+		slog.Info("Bucket is in another region, retrying there", "bucket", bucket, "region", region, "tried", bucketRegion)
+		bucketRegions.set(bucket, region)
+		persistRegion(bucket, region)
+		err = probeBucket(ctx, cfg, s3ClientFor(cfg, region, bucket), creds, bucket, key, optFns...)
+	}
+	allowed, err := isAllowed(ctx, err)
+	slog.Debug("Probe", "bucket", bucket, "key", key, "allowed", allowed, "err", err)
+	if err != nil {
+		emitEvent(progressEvent{Event: "probe_failed", Bucket: bucket, Error: err.Error()})
+	}
+	if captured != nil {
+		recordEvidence(ctx, bucket, roleArn, policy, allowed, err, captured)
+	}
+	return allowed, err
+}
 
-	var result bool
-	if key != "" {
-		// Try HeadObject
-		_, err := s3Svc.HeadObject(ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-		})
-		if err != nil {
-			var apiErr smithy.APIError
-			if errors.As(err, &apiErr) {
-				errorCode := apiErr.ErrorCode()
-				if errorCode == "403" || errorCode == "AccessDenied" || errorCode == "Forbidden" {
-					result = false
-				} else if errorCode == "404" || errorCode == "NotFound" {
-					result = true
-				} else {
-					log.Fatalf("Unexpected error code %s: %v", errorCode, err)
-				}
-			} else {
-				log.Fatalf("Unexpected error: %v", err)
-			}
-		} else {
-			result = true
-		}
-	} else {
-		// Try HeadBucket
-		_, err := s3Svc.HeadBucket(ctx, &s3.HeadBucketInput{
-			Bucket: aws.String(bucket),
-		})
-		if err != nil {
-			var apiErr smithy.APIError
-			if errors.As(err, &apiErr) {
-				errorCode := apiErr.ErrorCode()
-				if errorCode == "403" || errorCode == "AccessDenied" || errorCode == "Forbidden" {
-					result = false
-				} else if errorCode == "404" || errorCode == "NotFound" {
-					result = true
-				} else {
-					log.Fatalf("Unexpected error code %s: %v", errorCode, err)
-				}
-			} else {
-				log.Fatalf("Unexpected error: %v", err)
-			}
-		} else {
-			result = true
-		}
-	}
-
-	return result
+// Interprets a probe error as allowed (true) or denied (false), or returns an error
+// if it says neither
+func isAllowed(ctx context.Context, err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, context.Canceled) {
+		if parent, ok := ctx.Value(siblingParentKey{}).(context.Context); ok && parent.Err() == nil {
+			// The probe was abandoned because another one already found the digit
+			return false, nil
+		}
+		// The run or the target was cancelled, which says nothing about the digit
+		return false, err
+	}
+	if errors.Is(err, errNoBudget) {
+		return false, errNoBudget
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return false, fmt.Errorf("timed out: %w", err)
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false, fmt.Errorf("unexpected error: %w", err)
+	}
+	errorCode := apiErr.ErrorCode()
+	if errorCode == "403" || errorCode == "AccessDenied" || errorCode == "Forbidden" {
+		return false, nil
+	} else if errorCode == "404" || errorCode == "NotFound" || errorCode == "NoSuchKey" || errorCode == "InvalidRange" {
+		// Missing keys and unsatisfiable ranges are only reported once access is allowed
+		return true, nil
+	} else if _, ok := throttleErrorCodes[errorCode]; ok {
+		return false, fmt.Errorf("still throttled after retrying, lower -rate or raise -max-attempts: %w", err)
+	} else if _, ok := inconclusiveErrorCodes[errorCode]; ok {
+		return false, fmt.Errorf("%w (%s): %w", errInconclusive, errorCode, err)
+	}
+	return false, fmt.Errorf("unexpected error code %s: %w", errorCode, err)
 }
 
-// Converts the path to bucket and key
-func toS3Args(path string) (string, string) {
-	if strings.HasPrefix(path, "s3://") {
-		path = path[5:]
+// Error codes that say nothing about whether the session policy allowed the probe:
+// redirects left over after region recovery, requests the bucket configuration rejects
+// or doesn't support, and server errors that persisted through the retries
+var inconclusiveErrorCodes = map[string]struct{}{
+	"301": {}, "PermanentRedirect": {},
+	"400": {}, "BadRequest": {}, "InvalidRequest": {},
+	"405": {}, "MethodNotAllowed": {},
+	"501": {}, "NotImplemented": {},
+	"500": {}, "InternalError": {},
+	"503": {}, "ServiceUnavailable": {},
+}
+
+// Returned for probes answered with an inconclusive error code
+var errInconclusive = errors.New("inconclusive probe")
+
+// Returned, as part of a longer message, when the role can't access the target even
+// without a session policy, and when a digit couldn't be determined
+var (
+	errNoAccess   = errors.New("cannot access")
+	errIncomplete = errors.New("could not find all digits of the account ID")
+)
+
+// Reports whether the error is an inconclusive probe, warning that what is being skipped
+func skipInconclusive(err error, what string) bool {
+	if !errors.Is(err, errInconclusive) {
+		return false
 	}
-	parts := strings.SplitN(path, "/", 2)
-	if len(parts) > 1 {
-		return parts[0], parts[1]
+	slog.Warn("Skipping after an inconclusive probe", "skipped", what, "err", err)
+	return true
+}
+
+// Returns the resources the session policy is scoped to: the bucket and its objects, or
+// the ARN and everything under it. Targets whose resource ARN contains the very account
+// being searched for (aliases, directory buckets, Multi-Region Access Point aliases) and
+// Object Lambda, whose supporting access point and function aren't known, fall back to "*".
+func policyResources(bucket string) []string {
+	switch {
+	case isAccessPointAlias(bucket), isDirectoryBucket(bucket), isMultiRegionAccessPoint(bucket) && !arn.IsARN(bucket), isObjectLambda(bucket):
+		return []string{"*"}
+	case arn.IsARN(bucket):
+		return []string{bucket, bucket + "/*"}
 	}
-	return parts[0], ""
+	prefix := "arn:" + currentPartition().name + ":s3:::"
+	return []string{prefix + bucket, prefix + bucket + "/*"}
 }
 
 // Marshals the policy map to a JSON string
 func marshalPolicy(policy map[string]interface{}) string {
 	policyBytes, err := json.Marshal(policy)
 	if err != nil {
-		log.Fatalf("Failed to marshal policy: %v", err)
+		fatalConfigf("Failed to marshal policy: %v", err)
 	}
 	return string(policyBytes)
 }
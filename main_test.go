@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestResourceAccountPolicy(t *testing.T) {
+	tests := []struct {
+		bucket       string
+		conditionKey string
+		resources    []string
+	}{
+		{"bucket", "s3:ResourceAccount", []string{"arn:aws:s3:::bucket", "arn:aws:s3:::bucket/*"}},
+		{"ap-alias-abcdefghijklmnopqrstuvwxyz0123-s3alias", "s3:ResourceAccount", []string{"*"}},
+		{"base--usw2-az1--x-s3", "aws:ResourceAccount", []string{"*"}},
+		{"arn:aws:s3:us-west-2:111122223333:accesspoint/ap", "s3:ResourceAccount", []string{"arn:aws:s3:us-west-2:111122223333:accesspoint/ap", "arn:aws:s3:us-west-2:111122223333:accesspoint/ap/*"}},
+		{"arn:aws:s3-object-lambda:us-east-1:111122223333:accesspoint/olap", "aws:ResourceAccount", []string{"*"}},
+		{"arn:aws:s3-outposts:us-west-2:111122223333:outpost/op-01ac5d28a6a232904/bucket/b", "aws:ResourceAccount", []string{"arn:aws:s3-outposts:us-west-2:111122223333:outpost/op-01ac5d28a6a232904/bucket/b", "arn:aws:s3-outposts:us-west-2:111122223333:outpost/op-01ac5d28a6a232904/bucket/b/*"}},
+	}
+	values := []string{"1*", "2*"}
+	for _, tt := range tests {
+		policy := resourceAccountPolicy(tt.bucket, "StringLike", values)
+		statements := policy["Statement"].([]map[string]interface{})
+		if len(statements) != 1 {
+			t.Errorf("%s: got %d statements, want 1", tt.bucket, len(statements))
+			continue
+		}
+		allow := statements[0]
+		want := map[string]interface{}{"StringLike": map[string]interface{}{tt.conditionKey: values}}
+		if !reflect.DeepEqual(allow["Condition"], want) {
+			t.Errorf("%s: condition = %v, want %v", tt.bucket, allow["Condition"], want)
+		}
+		if !reflect.DeepEqual(allow["Resource"], tt.resources) {
+			t.Errorf("%s: resources = %v, want %v", tt.bucket, allow["Resource"], tt.resources)
+		}
+		if !reflect.DeepEqual(allow["Action"], probeActions(tt.bucket)) {
+			t.Errorf("%s: actions = %v, want %v", tt.bucket, allow["Action"], probeActions(tt.bucket))
+		}
+	}
+}
+
+func TestIsAllowedCancelled(t *testing.T) {
+	run, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+	sibling, cancelSibling := context.WithCancel(context.WithValue(run, siblingParentKey{}, run))
+	cancelSibling()
+	if allowed, err := isAllowed(sibling, context.Canceled); allowed || err != nil {
+		t.Errorf("sibling probe cancelled: got %v, %v, want a denial", allowed, err)
+	}
+
+	cancelRun()
+	if _, err := isAllowed(sibling, context.Canceled); !errors.Is(err, context.Canceled) || !isAborted(err) {
+		t.Errorf("run cancelled: got %v, want the cancellation as an abort", err)
+	}
+	if _, err := isAllowed(run, context.Canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("probe outside a race cancelled: got %v, want the cancellation", err)
+	}
+}
+
+func TestRedirectRegion(t *testing.T) {
+	withRegionHeader := func(err error, region string) error {
+		header := http.Header{}
+		header.Set("X-Amz-Bucket-Region", region)
+		return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: 301, Header: header}},
+			Err:      err,
+		}}
+	}
+	tests := []struct {
+		name       string
+		err        error
+		region     string
+		redirected bool
+	}{
+		{"no error", nil, "", false},
+		{"not an API error", errors.New("connection reset"), "", false},
+		{"redirect with header", withRegionHeader(&smithy.GenericAPIError{Code: "PermanentRedirect"}, "eu-west-1"), "eu-west-1", true},
+		{"HEAD redirect with header", withRegionHeader(&smithy.GenericAPIError{Code: "301"}, "ap-south-1"), "ap-south-1", true},
+		{"wrong signing region", &smithy.GenericAPIError{Code: "AuthorizationHeaderMalformed", Message: "the region 'us-east-1' is wrong; expecting 'eu-west-2'"}, "eu-west-2", true},
+		{"redirect without a region", &smithy.GenericAPIError{Code: "PermanentRedirect"}, "", false},
+		{"denied", withRegionHeader(&smithy.GenericAPIError{Code: "AccessDenied"}, "eu-west-1"), "", false},
+	}
+	for _, tt := range tests {
+		region, ok := redirectRegion(tt.err)
+		if region != tt.region || ok != tt.redirected {
+			t.Errorf("%s: redirectRegion() = %q, %v, want %q, %v", tt.name, region, ok, tt.region, tt.redirected)
+		}
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	defer func(n int) { maxDigits = n }(maxDigits)
+	tests := []struct {
+		name      string
+		maxDigits int
+		accountID string
+		err       error
+		want      int
+	}{
+		{"found", 12, "111122223333", nil, exitFound},
+		{"prefix asked for", 4, "1111", nil, exitFound},
+		{"partial", 12, "1111", nil, exitPartial},
+		{"incomplete", 12, "1111", errIncomplete, exitPartial},
+		{"no access", 12, "", errNoAccess, exitNoAccess},
+		{"interrupted", 12, "11", context.Canceled, exitAborted},
+		{"timed out", 12, "", fmt.Errorf("timed out: %w", context.DeadlineExceeded), exitAborted},
+		{"canary", 12, "", fmt.Errorf("bucket %w", errCanary), exitAborted},
+		{"throttled", 12, "111", &smithy.GenericAPIError{Code: "SlowDown"}, exitAborted},
+		{"other error", 12, "", &smithy.GenericAPIError{Code: "InvalidToken"}, exitNoAccess},
+	}
+	for _, tt := range tests {
+		maxDigits = tt.maxDigits
+		if got := exitCodeFor(tt.accountID, tt.err); got != tt.want {
+			t.Errorf("%s: exitCodeFor(%q, %v) = %d, want %d", tt.name, tt.accountID, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestWorseExitCode(t *testing.T) {
+	tests := []struct{ a, b, want int }{
+		{exitFound, exitFound, exitFound},
+		{exitFound, exitPartial, exitPartial},
+		{exitPartial, exitNoAccess, exitNoAccess},
+		{exitNoAccess, exitPartial, exitNoAccess},
+		{exitConfigError, exitNoAccess, exitConfigError},
+		{exitAborted, exitConfigError, exitAborted},
+		{exitFound, exitAborted, exitAborted},
+	}
+	for _, tt := range tests {
+		if got := worseExitCode(tt.a, tt.b); got != tt.want {
+			t.Errorf("worseExitCode(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPrintResultLine(t *testing.T) {
+	defer func(out *os.File, format string) { resultOut, outputFormat = out, format }(resultOut, outputFormat)
+	sameOrg := true
+	r := runResult{
+		Path: "s3://bucket", Bucket: "bucket", AccountID: "111122223333", Region: "eu-west-1", Status: "confirmed",
+		DigitsFound: 12, Duration: 1.5, APICalls: 40, Owner: "Example, Inc.", SameOrg: &sameOrg,
+	}
+	tests := []struct {
+		format, want string
+	}{
+		{"json", `{"path":"s3://bucket","bucket":"bucket","account_id":"111122223333","owner":"Example, Inc.","same_org":true,"region":"eu-west-1","status":"confirmed","digits_found":12,"duration":1.5,"api_calls":40}` + "\n"},
+		{"csv", `s3://bucket,,bucket,,111122223333,eu-west-1,confirmed,12,1.500,40,,,,"Example, Inc.",,,true` + "\n"},
+		{"grep", "bucket|eu-west-1|111122223333|confirmed\n"},
+	}
+	for _, tt := range tests {
+		f, err := os.CreateTemp(t.TempDir(), "results")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resultOut, outputFormat = f, tt.format
+		printResultLine(r)
+		f.Close()
+		got, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
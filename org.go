@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/smithy-go"
+)
+
+// Whether to look the accounts found up in the caller's organization, set from flags
+var orgLookup bool
+
+// An account of the caller's organization, as DescribeAccount returns it
+type orgAccount struct {
+	Name  string
+	Email string
+}
+
+var (
+	orgMu       sync.Mutex
+	orgAccounts = make(map[string]*orgAccount) // Accounts looked up, nil for those outside the organization
+	orgDisabled bool                           // Set once the caller turns out not to be able to describe accounts
+)
+
+// Looks a complete account ID up in the caller's organization, so that results from
+// scanning your own estate name the account. Callers without organizations:DescribeAccount,
+// or outside an organization, are found out on the first lookup and no more are made.
+// Failing doesn't stop the run.
+func lookupOrgAccount(ctx context.Context, cfg aws.Config, accountID string) {
+	if !orgLookup || len(accountID) != 12 {
+		return
+	}
+	orgMu.Lock()
+	_, done := orgAccounts[accountID]
+	disabled := orgDisabled
+	orgMu.Unlock()
+	if done || disabled {
+		return
+	}
+
+	// The lock isn't held across the call, so batch workers finding accounts at the same
+	// time don't queue behind each other's lookups. At worst an account is looked up twice.
+	account, err := describeOrgAccount(ctx, cfg, accountID)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccountNotFoundException":
+			err = nil // Not in the organization
+		case "AccessDeniedException", "AWSOrganizationsNotInUseException":
+			slog.Debug("Can't describe organization accounts, not looking found accounts up", "err", err)
+			orgMu.Lock()
+			orgDisabled = true
+			orgMu.Unlock()
+			return
+		}
+	}
+	if err != nil {
+		slog.Warn("Failed to look the account up in the organization", "account", accountID, "err", err)
+		return
+	}
+	orgMu.Lock()
+	defer orgMu.Unlock()
+	orgAccounts[accountID] = account
+}
+
+// Returns the organization account looked up for the account ID, or nil
+func orgAccountFor(accountID string) *orgAccount {
+	orgMu.Lock()
+	defer orgMu.Unlock()
+	return orgAccounts[accountID]
+}
+
+// Returns an Organizations client using the base credentials. Organizations is a
+// global service, served from a single region of each partition.
+func orgClient(cfg aws.Config) *organizations.Client {
+	return organizations.NewFromConfig(cfg, func(o *organizations.Options) {
+		o.Region = currentPartition().orgRegion
+	})
+}
+
+// Calls Organizations DescribeAccount. Failures, including AccountNotFoundException for
+// an account outside the organization, are API errors.
+func describeOrgAccount(ctx context.Context, cfg aws.Config, accountID string) (*orgAccount, error) {
+	out, err := orgClient(cfg).DescribeAccount(ctx, &organizations.DescribeAccountInput{
+		AccountId: aws.String(accountID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &orgAccount{
+		Name:  aws.ToString(out.Account.Name),
+		Email: aws.ToString(out.Account.Email),
+	}, nil
+}
+
+// Returns the ID of the caller's organization with DescribeOrganization, which any
+// account in the organization can call
+func describeOrgID(ctx context.Context, cfg aws.Config) (string, error) {
+	out, err := orgClient(cfg).DescribeOrganization(ctx, &organizations.DescribeOrganizationInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.Organization.Id), nil
+}
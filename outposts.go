@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/smithy-go"
+)
+
+// SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Converts an S3 on Outposts ARN to bucket and key. Access point ARNs
+// (outpost/<id>/accesspoint/<name>[/object/<key>]) are handed to the SDK, which routes
+// them to the s3-outposts endpoint. Bucket ARNs (outpost/<id>/bucket/<name>) are kept
+// whole and probed through the S3 control API since they have no data plane endpoint.
+func outpostsARNToS3Args(a arn.ARN) (string, string, error) {
+	if a.Region == "" || a.AccountID == "" {
+		return "", "", fmt.Errorf("outposts ARN %q must include a region and account", a.String())
+	}
+	parts := strings.SplitN(a.Resource, "/", 6)
+	if len(parts) < 4 || parts[0] != "outpost" || parts[1] == "" || parts[3] == "" {
+		return "", "", fmt.Errorf("unsupported S3 on Outposts ARN resource %q", a.Resource)
+	}
+
+	switch parts[2] {
+	case "bucket":
+		if len(parts) > 4 {
+			return "", "", fmt.Errorf("outposts bucket ARN %q can't name an object, use an access point ARN instead", a.String())
+		}
+		return a.String(), "", nil
+	case "accesspoint":
+		key := ""
+		if len(parts) == 6 && parts[4] == "object" {
+			key = parts[5]
+		} else if len(parts) > 4 {
+			return "", "", fmt.Errorf("unsupported S3 on Outposts ARN resource %q", a.Resource)
+		}
+		a.Resource = strings.Join(parts[:4], "/")
+		return a.String(), key, nil
+	}
+	return "", "", fmt.Errorf("unsupported S3 on Outposts ARN resource %q", a.Resource)
+}
+
+// Reports whether the bucket is an S3 on Outposts ARN or access point alias (xxxxxxxx--op-s3)
+func isOutposts(bucket string) bool {
+	return strings.HasPrefix(bucket, "arn:") && strings.Contains(bucket, ":s3-outposts:") ||
+		strings.HasSuffix(bucket, "--op-s3")
+}
+
+// Reports whether the bucket is an S3 on Outposts bucket ARN (as opposed to an access point)
+func isOutpostsBucketARN(bucket string) bool {
+	return isOutposts(bucket) && strings.Contains(bucket, "/bucket/")
+}
+
+// Issues the S3 control GetBucket call for an Outposts bucket ARN. Failures are
+// returned as API errors keyed by HTTP status so they classify like S3 probe errors.
+func headOutpostsBucket(ctx context.Context, cfg aws.Config, creds aws.CredentialsProvider, bucketARN string) error {
+	a, err := arn.Parse(bucketARN)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(a.Resource, "/") // outpost/<id>/bucket/<name>
+
+	service := "s3-outposts"
+	if useFIPS {
+		service = "s3-outposts-fips"
+	}
+	endpoint := fmt.Sprintf("https://%s.%s.%s/v20180820/bucket/%s", service, a.Region, partitionByName(a.Partition).dnsSuffix, url.PathEscape(parts[3]))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-amz-account-id", a.AccountID)
+	req.Header.Set("x-amz-outpost-id", parts[1])
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+	setUserAgent(req)
+
+	credentials, err := creds.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	if err := v4.NewSigner().SignHTTP(ctx, credentials, req, emptyPayloadHash, "s3-outposts", a.Region, time.Now()); err != nil {
+		return err
+	}
+
+	var client aws.HTTPClient = http.DefaultClient
+	if cfg.HTTPClient != nil {
+		client = cfg.HTTPClient
+	}
+	if err := waitForRate(ctx); err != nil {
+		return err
+	}
+	if err := chargeAPICall(); err != nil {
+		return err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	auditHTTP("S3 Outposts", "GetBucket", req, resp, start, err)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 300 {
+		return &smithy.GenericAPIError{Code: strconv.Itoa(resp.StatusCode), Message: strings.TrimSpace(string(body))}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Outcome of a single-target run, or of a batch target, for the structured formats
+type runResult struct {
+	Path          string  `json:"path,omitempty"`  // Batch target as listed
+	Label         string  `json:"label,omitempty"` // Batch target label
+	Bucket        string  `json:"bucket"`
+	Key           string  `json:"key,omitempty"`
+	AccountID     string  `json:"account_id"`
+	Owner         string  `json:"owner,omitempty"`             // Known owner of the account, e.g. a SaaS vendor
+	OrgAccount    string  `json:"org_account_name,omitempty"`  // Name of the account in the caller's organization
+	OrgEmail      string  `json:"org_account_email,omitempty"` // Its root email address
+	SameOrg       *bool   `json:"same_org,omitempty"`          // Whether the bucket is in the caller's organization, if checked
+	Region        string  `json:"region,omitempty"`
+	Status        string  `json:"status"` // confirmed, unconfirmed, complete, partial or error
+	DigitsFound   int     `json:"digits_found"`
+	Duration      float64 `json:"duration"`            // Seconds
+	APICalls      int64   `json:"api_calls,omitempty"` // Single-target runs only
+	Error         string  `json:"error,omitempty"`
+	CallerAccount string  `json:"caller_account,omitempty"` // Account of the base credentials
+	CallerARN     string  `json:"caller_arn,omitempty"`
+}
+
+// Format results are printed in, set from flags: text, json (a single target), jsonl
+// (one line per batch target), csv, grep or template
+var outputFormat = "text"
+
+// Template each result is printed with for -format template, set from flags
+var outputTemplate *template.Template
+
+// Parses -output-template, which implies -format template
+func parseOutputTemplate(s string) error {
+	t, err := template.New("output").Parse(s)
+	if err != nil {
+		return err
+	}
+	outputTemplate = t
+	outputFormat = "template"
+	return nil
+}
+
+// Columns of -format csv, in order. New columns only ever go on the end.
+var csvColumns = []string{"path", "label", "bucket", "key", "account_id", "region", "status", "digits_found", "duration", "api_calls", "error", "caller_account", "caller_arn", "owner", "org_account_name", "org_account_email", "same_org"}
+
+// Where structured results go: stdout, with progress output moved to stderr so that
+// stdout holds nothing but the results, or the -output file
+var resultOut *os.File
+
+// Whether results go to a file, synced after every result
+var resultToFile bool
+
+// The -output file being replaced, and the temporary file the results are written to
+// until the run finishes and it's renamed over it ("" when not replacing a file)
+var resultPath, resultTmpPath string
+
+// Sends the results to the file at path, appending to it or replacing it, or to stdout
+// if path is "", and writes the CSV header unless appending to a file that has one. A
+// replaced file is only swapped for the new results by finishStructuredOutput, so a run
+// that fails to start leaves the previous results in place.
+func enableStructuredOutput(path string, appendOutput bool) error {
+	switch {
+	case path == "":
+		resultOut = os.Stdout
+		os.Stdout = os.Stderr
+	case appendOutput || !isRegularOrMissing(path):
+		// Devices and pipes such as /dev/stdout are written to directly
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if appendOutput {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(path, flags, 0o644)
+		if err != nil {
+			return err
+		}
+		resultOut, resultToFile = f, true
+	default:
+		f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+		if err != nil {
+			return err
+		}
+		if err := f.Chmod(0o644); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+		resultOut, resultToFile = f, true
+		resultPath, resultTmpPath = path, f.Name()
+	}
+	if outputFormat == "csv" {
+		if info, err := resultOut.Stat(); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+			return nil
+		}
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		w.Write(csvColumns)
+		w.Flush()
+		writeResult(b.Bytes())
+	}
+	return nil
+}
+
+// Returns the format results written to the file at path default to: CSV for .csv
+// files, or else JSON lines
+func fileFormat(path string, batch bool) string {
+	switch {
+	case strings.EqualFold(filepath.Ext(path), ".csv"):
+		return "csv"
+	case batch:
+		return "jsonl"
+	}
+	return "json"
+}
+
+// Builds the result of searching the bucket, which took elapsed. A complete account ID
+// without a confirmation status (confirmation disabled, or found by an earlier run) is
+// "complete".
+func newRunResult(bucket, key, accountID, status string, elapsed time.Duration, err error) runResult {
+	r := runResult{
+		Bucket:        bucket,
+		Key:           key,
+		AccountID:     accountID,
+		Owner:         vendorName(accountID),
+		Status:        status,
+		DigitsFound:   len(accountID),
+		Duration:      elapsed.Round(time.Millisecond).Seconds(),
+		CallerAccount: callerIdentity.Account,
+		CallerARN:     callerIdentity.ARN,
+	}
+	r.Region, _ = bucketRegions.get(bucket)
+	if account := orgAccountFor(accountID); account != nil {
+		r.OrgAccount, r.OrgEmail = account.Name, account.Email
+	}
+	r.SameOrg = sameOrgFor(bucket)
+	switch {
+	case err != nil:
+		r.Status = "error"
+		r.Error = err.Error()
+	case len(accountID) < 12:
+		r.Status = "partial"
+	case status == "":
+		r.Status = "complete"
+	}
+	return r
+}
+
+// Whether to print nothing on stdout but the account ID, or prefix with -max-digits,
+// set from flags. Errors and incomplete prefixes print nothing there.
+var quiet bool
+
+// Records a finished target's result in -db, -progress-json, -evidence and -stats
+func recordFinished(r runResult) {
+	recordResult(r)
+	recordTargetStat(r)
+	emitTargetFinished(r)
+	writeEvidenceSummary(r)
+}
+
+// Records a single target's result and prints it in the -format chosen, or just the
+// account ID with -quiet, if not text
+func printRunResult(r runResult) {
+	recordFinished(r)
+	if resultOut == nil {
+		return
+	}
+	if quiet {
+		if r.Error == "" && r.DigitsFound >= maxDigits {
+			writeResult([]byte(r.AccountID + "\n"))
+		}
+		return
+	}
+	r.APICalls = apiCalls.Load()
+	printResultLine(r)
+}
+
+// Prints a result as a JSON line, CSV row, grep line or with the template
+func printResultLine(r runResult) {
+	var b bytes.Buffer
+	switch outputFormat {
+	case "csv":
+		writeCSVRow(&b, r)
+	case "grep":
+		// In the spirit of nmap -oG: one line per target, fields split with |
+		fmt.Fprintf(&b, "%s|%s|%s|%s\n", r.Bucket, r.Region, r.AccountID, r.Status)
+	case "template":
+		if err := writeTemplate(&b, r); err != nil {
+			slog.Error("Failed to print the result with the output template", "bucket", r.Bucket, "err", err)
+			return
+		}
+	default:
+		json.NewEncoder(&b).Encode(r)
+	}
+	writeResult(b.Bytes())
+}
+
+// Reports whether path is a regular file or doesn't exist yet
+func isRegularOrMissing(path string) bool {
+	info, err := os.Stat(path)
+	return os.IsNotExist(err) || err == nil && info.Mode().IsRegular()
+}
+
+// Renames the results written so far over the -output file being replaced
+func finishStructuredOutput() {
+	if resultTmpPath == "" {
+		return
+	}
+	err := resultOut.Close()
+	if err == nil {
+		err = os.Rename(resultTmpPath, resultPath)
+	}
+	if err != nil {
+		os.Remove(resultTmpPath)
+		slog.Error("Failed to write output", "err", err)
+	}
+	resultTmpPath = ""
+}
+
+// Removes the results of a run that didn't start, leaving the -output file as it was
+func discardStructuredOutput() {
+	if resultTmpPath == "" {
+		return
+	}
+	resultOut.Close()
+	os.Remove(resultTmpPath)
+	resultTmpPath = ""
+}
+
+// Writes a result with a single write, synced to disk when it's going to a file, so
+// that a run cut off by a terminal disconnect keeps every result written so far and
+// runs appending to the same file never interleave half lines
+func writeResult(p []byte) {
+	resultOut.Write(p)
+	if resultToFile {
+		resultOut.Sync()
+	}
+}
+
+// Writes a result as a row of csvColumns
+func writeCSVRow(w io.Writer, r runResult) {
+	apiCalls := ""
+	if r.APICalls > 0 {
+		apiCalls = strconv.FormatInt(r.APICalls, 10)
+	}
+	sameOrg := ""
+	if r.SameOrg != nil {
+		sameOrg = strconv.FormatBool(*r.SameOrg)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		r.Path, r.Label, r.Bucket, r.Key, r.AccountID, r.Region, r.Status,
+		strconv.Itoa(r.DigitsFound), strconv.FormatFloat(r.Duration, 'f', 3, 64), apiCalls, r.Error,
+		r.CallerAccount, r.CallerARN, r.Owner, r.OrgAccount, r.OrgEmail, sameOrg,
+	})
+	cw.Flush()
+}
+
+// Writes a result with -output-template, ending it with a newline if the template doesn't
+func writeTemplate(w *bytes.Buffer, r runResult) error {
+	if err := outputTemplate.Execute(w, r); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(w.Bytes(), []byte("\n")) {
+		w.WriteString("\n")
+	}
+	return nil
+}
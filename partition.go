@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// An AWS partition: its own ARNs, endpoints and regions
+type partition struct {
+	name          string
+	defaultRegion string   // Region for lookups that aren't tied to a bucket yet
+	dnsSuffix     string   // Domain of the service endpoints
+	s3Host        string   // Endpoint for unsigned requests that don't know the bucket's region
+	orgRegion     string   // Region of the Organizations endpoint
+	stsRegions    []string // Regions with STS enabled by default
+	regions       []string // Regions tried in turn when a region can't be looked up
+}
+
+// Partitions by name. GovCloud and China have no global S3 endpoint, so requests that
+// don't know the region yet go to the partition's default region.
+var partitions = map[string]partition{
+	"aws": {
+		name:          "aws",
+		defaultRegion: "us-east-1",
+		dnsSuffix:     "amazonaws.com",
+		s3Host:        "s3.amazonaws.com",
+		orgRegion:     "us-east-1",
+		stsRegions:    defaultSTSRegions,
+		regions:       aliasSearchRegions,
+	},
+	"aws-us-gov": {
+		name:          "aws-us-gov",
+		defaultRegion: "us-gov-west-1",
+		dnsSuffix:     "amazonaws.com",
+		s3Host:        "s3.us-gov-west-1.amazonaws.com",
+		orgRegion:     "us-gov-west-1",
+		stsRegions:    []string{"us-gov-west-1", "us-gov-east-1"},
+		regions:       []string{"us-gov-west-1", "us-gov-east-1"},
+	},
+	"aws-cn": {
+		name:          "aws-cn",
+		defaultRegion: "cn-north-1",
+		dnsSuffix:     "amazonaws.com.cn",
+		s3Host:        "s3.cn-north-1.amazonaws.com.cn",
+		orgRegion:     "cn-northwest-1",
+		stsRegions:    []string{"cn-north-1", "cn-northwest-1"},
+		regions:       []string{"cn-north-1", "cn-northwest-1"},
+	},
+}
+
+// Commercial regions with STS enabled by default. STS in opt-in regions only answers accounts that
+// have enabled the region, so these are the only ones chosen automatically.
+var defaultSTSRegions = []string{
+	"us-east-1", "us-east-2", "us-west-1", "us-west-2", "ca-central-1",
+	"eu-west-1", "eu-west-2", "eu-west-3", "eu-central-1", "eu-north-1",
+	"ap-northeast-1", "ap-northeast-2", "ap-northeast-3", "ap-southeast-1", "ap-southeast-2",
+	"ap-south-1", "sa-east-1",
+}
+
+// Name of the partition being worked in, taken from the role ARN or else the
+// configured region ("" until known)
+var partitionName string
+
+// Returns the partition being worked in, the commercial one by default
+func currentPartition() partition {
+	return partitionByName(partitionName)
+}
+
+// Returns the named partition, or the commercial one if it isn't known
+func partitionByName(name string) partition {
+	if p, ok := partitions[name]; ok {
+		return p
+	}
+	return partitions["aws"]
+}
+
+// Returns the base URL for unsigned path-style requests that don't know the bucket's
+// region: -s3-endpoint if given, or else the partition's S3 endpoint
+func s3BaseURL() string {
+	if s3EndpointURL != "" {
+		return strings.TrimSuffix(s3EndpointURL, "/")
+	}
+	return "https://" + s3Endpoint()
+}
+
+// Returns the S3 endpoint for unsigned requests that don't know the bucket's region,
+// honouring -fips and -dualstack. The variants only exist regionally, so they use the
+// partition's default region.
+func s3Endpoint() string {
+	p := currentPartition()
+	if !useFIPS && !useDualStack {
+		return p.s3Host
+	}
+	host := "s3"
+	if useFIPS {
+		host = "s3-fips"
+	}
+	if useDualStack {
+		host += ".dualstack"
+	}
+	return host + "." + p.defaultRegion + "." + p.dnsSuffix
+}
+
+// Sets the partition from the (first) role ARN, if it names a known one
+func setPartitionFromRole(roleArn string) {
+	a, err := arn.Parse(strings.SplitN(roleArn, ",", 2)[0])
+	if err != nil {
+		return
+	}
+	if _, ok := partitions[a.Partition]; ok {
+		partitionName = a.Partition
+	}
+}
+
+// Returns the partition a region belongs to
+func partitionForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	}
+	return "aws"
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Statements from -session-policy-file, included in every session policy
+var (
+	baseStatements []map[string]interface{}
+	baseCondition  map[string]interface{} // Merged into the generated Allow statement
+)
+
+// Loads the base session policy file. Deny statements are included in every session
+// policy as they are. An Allow statement with the Sid AllowResourceAccount contributes
+// its Condition to the generated statement; any other Allow statement would grant
+// access whatever the bucket owner, so it's rejected.
+func loadSessionPolicy(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var doc struct {
+		Statement json.RawMessage
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid session policy: %w", err)
+	}
+
+	// Statement may be a single statement or a list of them
+	var statements []map[string]interface{}
+	if err := json.Unmarshal(doc.Statement, &statements); err != nil {
+		var statement map[string]interface{}
+		if err := json.Unmarshal(doc.Statement, &statement); err != nil {
+			return fmt.Errorf("invalid session policy statement: %w", err)
+		}
+		statements = []map[string]interface{}{statement}
+	}
+
+	for i, statement := range statements {
+		switch {
+		case statement["Effect"] == "Deny":
+			baseStatements = append(baseStatements, statement)
+		case statement["Sid"] == "AllowResourceAccount":
+			condition, ok := statement["Condition"].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("statement %d: AllowResourceAccount needs a Condition to merge", i+1)
+			}
+			baseCondition = condition
+		default:
+			return fmt.Errorf("statement %d: only Deny statements and an AllowResourceAccount condition can be added", i+1)
+		}
+	}
+	return nil
+}
+
+// Builds the session policy around the generated Allow statement, merging in the base
+// condition (the generated keys win) and appending the base Deny statements
+func sessionPolicy(allow map[string]interface{}) map[string]interface{} {
+	if len(baseCondition) > 0 {
+		condition, _ := allow["Condition"].(map[string]interface{})
+		merged := make(map[string]interface{}, len(baseCondition)+len(condition))
+		for operator, keys := range baseCondition {
+			merged[operator] = keys
+		}
+		for operator, keys := range condition {
+			if base, ok := merged[operator].(map[string]interface{}); ok {
+				combined := make(map[string]interface{}, len(base)+1)
+				for k, v := range base {
+					combined[k] = v
+				}
+				for k, v := range keys.(map[string]interface{}) {
+					combined[k] = v
+				}
+				keys = combined
+			}
+			merged[operator] = keys
+		}
+		allow["Condition"] = merged
+	}
+	return map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": append([]map[string]interface{}{allow}, baseStatements...),
+	}
+}
+
+// Returns the session policy for probes that shouldn't be restricted to any account:
+// none at all, unless a base policy has to be included in every session policy or
+// the sessions are federated
+func unrestrictedPolicy(bucket string) map[string]interface{} {
+	if baseStatements == nil && baseCondition == nil && !federation {
+		// Federated sessions have no permissions at all without a session policy
+		return nil
+	}
+	return sessionPolicy(map[string]interface{}{
+		"Sid":      "AllowResourceAccount",
+		"Effect":   "Allow",
+		"Action":   probeActions(bucket),
+		"Resource": policyResources(bucket),
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSessionPolicy(t *testing.T) {
+	tests := []struct {
+		name, policy string
+		ok           bool
+	}{
+		{"deny", `{"Statement": {"Effect": "Deny", "Action": "s3:PutObject", "Resource": "*"}}`, true},
+		{"condition", `{"Statement": [{"Sid": "AllowResourceAccount", "Effect": "Allow", "Condition": {"IpAddress": {"aws:SourceIp": "203.0.113.0/24"}}}]}`, true},
+		{"allow", `{"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "*"}]}`, false},
+		{"no condition", `{"Statement": [{"Sid": "AllowResourceAccount", "Effect": "Allow"}]}`, false},
+		{"invalid", `{"Statement": "s3:*"}`, false},
+	}
+	defer func(statements []map[string]interface{}, condition map[string]interface{}) {
+		baseStatements, baseCondition = statements, condition
+	}(baseStatements, baseCondition)
+	for _, tt := range tests {
+		baseStatements, baseCondition = nil, nil
+		filename := filepath.Join(t.TempDir(), "policy.json")
+		if err := os.WriteFile(filename, []byte(tt.policy), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadSessionPolicy(filename); (err == nil) != tt.ok {
+			t.Errorf("%s: loadSessionPolicy() error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestSessionPolicyMergesBaseCondition(t *testing.T) {
+	defer func(statements []map[string]interface{}, condition map[string]interface{}) {
+		baseStatements, baseCondition = statements, condition
+	}(baseStatements, baseCondition)
+	deny := map[string]interface{}{"Effect": "Deny", "Action": "s3:PutObject", "Resource": "*"}
+	baseStatements = []map[string]interface{}{deny}
+	baseCondition = map[string]interface{}{
+		"StringLike": map[string]interface{}{"aws:PrincipalTag/team": "recon", "s3:ResourceAccount": []string{"9*"}},
+		"Bool":       map[string]interface{}{"aws:SecureTransport": "true"},
+	}
+
+	policy := resourceAccountPolicy("bucket", "StringLike", []string{"1*"})
+	statements := policy["Statement"].([]map[string]interface{})
+	if len(statements) != 2 || !reflect.DeepEqual(statements[1], deny) {
+		t.Fatalf("statements = %v, want the Allow statement then the base Deny", statements)
+	}
+	want := map[string]interface{}{
+		"StringLike": map[string]interface{}{"aws:PrincipalTag/team": "recon", "s3:ResourceAccount": []string{"1*"}},
+		"Bool":       map[string]interface{}{"aws:SecureTransport": "true"},
+	}
+	if got := statements[0]["Condition"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("condition = %v, want %v", got, want)
+	}
+}
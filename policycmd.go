@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Prints the IAM policies needed to set up the scanning role: its permissions policy,
+// its trust policy and the policy letting the caller assume it
+func runPolicy(args []string) {
+	fs := flag.NewFlagSet("policy", flag.ContinueOnError)
+	roleArn := fs.String("role_arn", "", "ARN of the scanning role, to scope the caller's sts:AssumeRole permission (default: any role)")
+	bucket := fs.String("bucket", "", "scope the permissions to this bucket (default: all buckets)")
+	principal := fs.String("principal", "", "principal allowed to assume the role (default: the root of your base credentials' account)")
+	fs.StringVar(&probeOperation, "probe", "", "S3 operation the role will probe with: head-object, get-object, get-object-attributes, list-objects or head-bucket (default: chosen per target)")
+	addRequestFlags(fs)
+	addLogFlags(fs)
+	parseFlags(fs, args)
+	initLogging()
+
+	if probeOperation != "" && probeOperations[probeOperation] == nil {
+		fatalConfigf("unknown probe %q, expected head-object, get-object, get-object-attributes, list-objects or head-bucket", probeOperation)
+	}
+	setPartitionFromRole(*roleArn)
+	if *principal == "" {
+		ctx, cancel := runContext()
+		defer cancel()
+		out, err := stsClientFor(loadConfig(ctx), "").GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			fatalConfigf("failed to look up your account, give -principal instead: %v", err)
+		}
+		caller, err := arn.Parse(aws.ToString(out.Arn))
+		if err != nil {
+			fatalConfigf("failed to parse your identity's ARN, give -principal instead: %v", err)
+		}
+		*principal = "arn:" + caller.Partition + ":iam::" + caller.AccountID + ":root"
+	}
+
+	resources := []string{"*"}
+	if *bucket != "" {
+		resources = policyResources(*bucket)
+	}
+	assumable := "*"
+	if *roleArn != "" {
+		assumable = *roleArn
+	}
+
+	printPolicy("Permissions policy (attach to the scanning role)", map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Sid":      "ProbeBuckets",
+				"Effect":   "Allow",
+				"Action":   probeActions(*bucket),
+				"Resource": resources,
+			},
+		},
+	})
+	// sts:SetSourceIdentity lets runs with -source-identity assume the role too
+	trust := map[string]interface{}{
+		"Effect":    "Allow",
+		"Principal": map[string]interface{}{"AWS": *principal},
+		"Action":    []string{"sts:AssumeRole", "sts:SetSourceIdentity"},
+	}
+	if externalID != "" {
+		trust["Condition"] = map[string]interface{}{
+			"StringEquals": map[string]interface{}{"sts:ExternalId": externalID},
+		}
+	}
+	printPolicy("Trust policy (the scanning role's trust relationship)", map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": []map[string]interface{}{trust},
+	})
+	printPolicy("Caller policy (attach to the identity running S3AccountFinder)", map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Effect":   "Allow",
+				"Action":   "sts:AssumeRole",
+				"Resource": assumable,
+			},
+		},
+	})
+}
+
+// Prints a titled, indented policy document
+func printPolicy(title string, policy map[string]interface{}) {
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		fatalConfigf("Failed to marshal policy: %v", err)
+	}
+	fmt.Printf("# %s\n%s\n\n", title, data)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Runs job for each index from 0 to n-1 on at most workers goroutines, blocking until
+// every started job has returned. Once ctx is cancelled no further jobs are started,
+// so a caller can stop the pool early by cancelling it. This is the one scheduler
+// behind digit probing, batch targets and bucket existence checks.
+func runPool(ctx context.Context, workers, n int, job func(ctx context.Context, i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				job(ctx, i)
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// A mutex-protected map shared by every worker
+type sharedCache[V any] struct {
+	mu      sync.Mutex
+	entries map[string]V
+}
+
+// Creates an empty cache
+func newSharedCache[V any]() *sharedCache[V] {
+	return &sharedCache[V]{entries: make(map[string]V)}
+}
+
+// Returns the entry for key, if there is one
+func (c *sharedCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries[key]
+	return v, ok
+}
+
+// Sets the entry for key
+func (c *sharedCache[V]) set(key string, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = v
+}
+
+// Sets the entry for key unless it already has one
+func (c *sharedCache[V]) setDefault(key string, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = v
+	}
+}
+
+// Returns a copy of every entry
+func (c *sharedCache[V]) snapshot() map[string]V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make(map[string]V, len(c.entries))
+	for k, v := range c.entries {
+		entries[k] = v
+	}
+	return entries
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Tests access to a bucket or key with one S3 operation
+type probeFunc func(ctx context.Context, s3Svc *s3.Client, bucket, key string, optFns ...func(*s3.Options)) error
+
+// Operations selectable with -probe, for roles that only have some S3 permissions
+var probeOperations = map[string]probeFunc{
+	"head-object":           headObjectProbe,
+	"get-object":            getObjectProbe,
+	"get-object-attributes": getObjectAttributesProbe,
+	"list-objects":          listObjectsProbe,
+	"head-bucket":           headBucketProbe,
+}
+
+// Operation to probe with, set from flags ("" picks one per target)
+var probeOperation string
+
+// Operations tried in turn when the preferred one is denied even without a session policy
+var probeFallbackChain = []string{"head-object", "get-object", "list-objects", "head-bucket"}
+
+// Operations locked in per bucket after falling back
+var lockedProbes = newSharedCache[string]()
+
+// Returns the operation to probe the bucket with, "" to pick one from the target
+func probeFor(bucket string) string {
+	if op, ok := lockedProbes.get(bucket); ok {
+		return op
+	}
+	return probeOperation
+}
+
+// Returns the operation the default probe picks for the target, "" for the CreateSession
+// of a directory bucket
+func defaultProbe(bucket, key string) string {
+	switch {
+	case isDirectoryBucket(bucket) && key == "":
+		return ""
+	case isObjectLambda(bucket) && key == "":
+		// Object Lambda access points don't support HeadBucket, so list a single key instead
+		return "list-objects"
+	case strings.HasSuffix(key, "/"):
+		// Folder-style keys are probed by listing a single key under the prefix
+		return "list-objects"
+	case key != "":
+		return "head-object"
+	}
+	return "head-bucket"
+}
+
+// Carries the fallback operation being tried to probeBucket, so that trying one doesn't
+// change the probe of other searches of the bucket
+type trialProbeKey struct{}
+
+// Tries each operation in the fallback chain without a session policy and locks in the
+// first one allowed for the rest of the bucket's search. The operation already probed
+// with, and object operations when there's no key, are skipped.
+func fallbackProbe(ctx context.Context, cfg aws.Config, bucket, key, roleArn string) (string, bool, error) {
+	probed := probeFor(bucket)
+	if probed == "" {
+		probed = defaultProbe(bucket, key)
+	}
+	for _, op := range probeFallbackChain {
+		if op == probed || key == "" && (op == "head-object" || op == "get-object") {
+			continue
+		}
+		allowed, err := canAccessWithPolicy(context.WithValue(ctx, trialProbeKey{}, op), cfg, bucket, key, roleArn, nil)
+		if err != nil && !skipInconclusive(err, op) {
+			return "", false, err
+		}
+		if allowed {
+			lockedProbes.set(bucket, op)
+			return op, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// Actions granted by every session policy, set from flags (empty for just the ones the
+// probes need)
+var policyActions []string
+
+// Returns the actions the session policy needs to allow for probing the bucket. Granting
+// only these, rather than s3:*, looks far less alarming in CloudTrail and survives
+// permission boundaries that deny s3:*.
+func probeActions(bucket string) []string {
+	if len(policyActions) > 0 {
+		return policyActions
+	}
+	switch {
+	case isOutposts(bucket):
+		// S3 on Outposts has its own action namespace, and buckets are probed with GetBucket
+		return []string{"s3-outposts:GetBucket", "s3-outposts:GetObject", "s3-outposts:ListBucket"}
+	case isDirectoryBucket(bucket):
+		// Directory buckets are authorised through s3express:CreateSession
+		return []string{"s3express:CreateSession"}
+	case isObjectLambda(bucket):
+		// Object Lambda requests also need the supporting access point and the function
+		return []string{"s3-object-lambda:GetObject", "s3-object-lambda:ListBucket", "s3:GetObject", "s3:ListBucket", "lambda:InvokeFunction"}
+	}
+	// s3:ListBucket also covers looking the region up with HeadBucket
+	actions := []string{"s3:GetObject", "s3:ListBucket"}
+	if probeFor(bucket) == "get-object-attributes" {
+		actions = append(actions, "s3:GetObjectAttributes")
+	}
+	return actions
+}
+
+// Reports whether the probe operation works on an object and so needs a key
+func probeNeedsKey() bool {
+	switch probeOperation {
+	case "head-object", "get-object", "get-object-attributes":
+		return true
+	}
+	return false
+}
+
+// Probes the bucket or key with the operation chosen for the target, signed with creds
+func probeBucket(ctx context.Context, cfg aws.Config, s3Svc *s3.Client, creds aws.CredentialsProvider, bucket, key string, optFns ...func(*s3.Options)) error {
+	opts := append([]func(*s3.Options){withCredentials(creds)}, optFns...)
+	if isOutpostsBucketARN(bucket) {
+		// Outposts buckets are only reachable through the S3 control API
+		return headOutpostsBucket(ctx, cfg, creds, bucket)
+	}
+	op, ok := ctx.Value(trialProbeKey{}).(string)
+	if !ok {
+		op = probeFor(bucket)
+	}
+	if op == "" {
+		op = defaultProbe(bucket, key)
+	}
+	if op != "" {
+		return probeOperations[op](ctx, s3Svc, bucket, key, opts...)
+	}
+	// Every directory bucket request starts with CreateSession, so probe that directly
+	_, err := s3Svc.CreateSession(ctx, &s3.CreateSessionInput{
+		Bucket: aws.String(bucket),
+	}, opts...)
+	return err
+}
+
+// Sets the x-amz-expected-bucket-owner header on any S3 operation, so that S3 denies the
+// request unless the bucket is owned by the account
+func withExpectedBucketOwner(accountID string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Build.Add(middleware.BuildMiddlewareFunc("ExpectedBucketOwner",
+				func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+					if req, ok := in.Request.(*smithyhttp.Request); ok {
+						req.Header.Set("X-Amz-Expected-Bucket-Owner", accountID)
+					}
+					return next.HandleBuild(ctx, in)
+				}), middleware.After)
+		})
+	}
+}
+
+// Probes with HeadObject (s3:GetObject)
+func headObjectProbe(ctx context.Context, s3Svc *s3.Client, bucket, key string, optFns ...func(*s3.Options)) error {
+	_, err := s3Svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, optFns...)
+	return err
+}
+
+// Probes with a single byte GetObject (s3:GetObject), for roles or bucket policies that
+// treat HEAD differently
+func getObjectProbe(ctx context.Context, s3Svc *s3.Client, bucket, key string, optFns ...func(*s3.Options)) error {
+	out, err := s3Svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String("bytes=0-0"),
+	}, optFns...)
+	if err != nil {
+		return err
+	}
+	return out.Body.Close()
+}
+
+// Probes with GetObjectAttributes (s3:GetObjectAttributes)
+func getObjectAttributesProbe(ctx context.Context, s3Svc *s3.Client, bucket, key string, optFns ...func(*s3.Options)) error {
+	_, err := s3Svc.GetObjectAttributes(ctx, &s3.GetObjectAttributesInput{
+		Bucket:           aws.String(bucket),
+		Key:              aws.String(key),
+		ObjectAttributes: []types.ObjectAttributes{types.ObjectAttributesObjectSize},
+	}, optFns...)
+	return err
+}
+
+// Probes by listing a single key (s3:ListBucket), under the key as a prefix if given.
+// An empty listing still means the request was allowed.
+func listObjectsProbe(ctx context.Context, s3Svc *s3.Client, bucket, key string, optFns ...func(*s3.Options)) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int32(1),
+	}
+	if key != "" {
+		input.Prefix = aws.String(key)
+	}
+	_, err := s3Svc.ListObjectsV2(ctx, input, optFns...)
+	return err
+}
+
+// Probes with HeadBucket (s3:ListBucket)
+func headBucketProbe(ctx context.Context, s3Svc *s3.Client, bucket, _ string, optFns ...func(*s3.Options)) error {
+	_, err := s3Svc.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	}, optFns...)
+	return err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Whether to show the progress bar when stderr is a terminal, set from flags
+var showProgress = true
+
+// Probes made so far, counted for the progress bar
+var probesIssued atomic.Int64
+
+// The progress bar of a single-target search, redrawn in place on stderr
+type progressBar struct {
+	mu       sync.Mutex
+	start    time.Time
+	initial  int // Digits known before the search started
+	digits   int
+	probes0  int64
+	stop     chan struct{}
+	done     chan struct{}
+	lastLine int
+}
+
+// The bar being shown, nil if none
+var activeProgress atomic.Pointer[progressBar]
+
+// Reports whether stderr is a terminal the bar can be redrawn on
+func stderrIsTerminal() bool {
+	return isTerminal(os.Stderr)
+}
+
+// Marks the context of the single-target run, whose search shows progress; batch
+// targets report theirs through the dashboard instead
+type progressKey struct{}
+
+// Returns the context with progress shown for its search
+func withProgress(ctx context.Context) context.Context {
+	return context.WithValue(ctx, progressKey{}, true)
+}
+
+// Reports whether the search run with the context shows progress
+func showsProgress(ctx context.Context) bool {
+	return ctx.Value(progressKey{}) != nil
+}
+
+// Starts showing progress for a search starting after the known digits, if stderr is a
+// terminal, and returns the function stopping it. Otherwise the plain start message is
+// printed.
+func startProgress(knownDigits string) func() {
+	if !showProgress || !stderrIsTerminal() {
+		fmt.Println("Starting search (this can take a while)")
+		return func() {}
+	}
+	p := &progressBar{
+		start:   time.Now(),
+		initial: len(knownDigits),
+		digits:  len(knownDigits),
+		probes0: probesIssued.Load(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	activeProgress.Store(p)
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			p.draw()
+			select {
+			case <-ticker.C:
+			case <-p.stop:
+				p.clear()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(p.stop)
+		<-p.done
+		activeProgress.Store(nil)
+	}
+}
+
+// Sets the digits found for the bucket, for the bar or the dashboard
+func updateProgress(bucket, prefix string) {
+	if d := activeDashboard.Load(); d != nil {
+		d.progress(bucket, prefix)
+	}
+	if p := activeProgress.Load(); p != nil {
+		p.mu.Lock()
+		p.digits = len(prefix)
+		p.mu.Unlock()
+		p.draw()
+	}
+}
+
+// Prints a line of search output in the colour, clearing the bar first so that the line
+// isn't appended to it. The bar is redrawn on the next tick.
+func progressPrintf(color, format string, a ...interface{}) {
+	if p := activeProgress.Load(); p != nil {
+		p.clear()
+	}
+	colorPrintf(os.Stdout, color, format, a...)
+}
+
+// Redraws the bar: digits found, probes made, the probe rate and an ETA from the time
+// each digit has taken so far
+func (p *progressBar) draw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	elapsed := time.Since(p.start)
+	probes := probesIssued.Load() - p.probes0
+	rate := float64(probes) / elapsed.Seconds()
+
+	eta := "--"
+	if found := p.digits - p.initial; found > 0 {
+		perDigit := elapsed / time.Duration(found)
+		eta = (perDigit * time.Duration(maxDigits-p.digits)).Round(time.Second).String()
+	}
+	bar := strings.Repeat("#", p.digits) + strings.Repeat("-", maxDigits-p.digits)
+	line := fmt.Sprintf("[%s] %d/%d digits | %d probes | %.1f probes/s | ETA %s",
+		bar, p.digits, maxDigits, probes, rate, eta)
+	fmt.Fprintf(os.Stderr, "\r\x1b[K%s", line)
+	p.lastLine = len(line)
+}
+
+// Clears the bar's line
+func (p *progressBar) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lastLine > 0 {
+		fmt.Fprint(os.Stderr, "\r\x1b[K")
+		p.lastLine = 0
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// Proxy every request goes through, set from flags ("" for HTTP_PROXY and friends from
+// the environment). http, https, socks5 and socks5h URLs are accepted.
+var proxyURL string
+
+// Whether to skip TLS certificate verification, set from flags, for corporate proxies
+// that intercept TLS with a CA that can't be installed
+var insecureTLS bool
+
+// Reports whether the SDK needs our HTTP client rather than its default one
+func customHTTPClient() bool {
+	return proxyURL != "" || insecureTLS
+}
+
+var (
+	proxyHTTPClient     *awshttp.BuildableClient
+	proxyHTTPClientOnce sync.Once
+)
+
+// Checks the -proxy URL, returning it parsed
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https, socks5 or socks5h", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	return u, nil
+}
+
+// Returns the proxy function for transports: -proxy if given, or else the environment
+func proxyFunc() func(*http.Request) (*url.URL, error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment
+	}
+	u, err := parseProxyURL(proxyURL)
+	if err != nil {
+		// Fail every request rather than silently connecting directly
+		return func(*http.Request) (*url.URL, error) {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+	}
+	return http.ProxyURL(u)
+}
+
+// Returns the HTTP client the SDK's STS and S3 clients share, sending requests through
+// -proxy and skipping verification with -insecure. net/http speaks SOCKS5 itself, so no
+// dialer of our own is needed.
+func sdkHTTPClient() *awshttp.BuildableClient {
+	proxyHTTPClientOnce.Do(func() {
+		proxyHTTPClient = awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+			tr.Proxy = proxyFunc()
+			skipVerify(tr)
+		})
+	})
+	return proxyHTTPClient
+}
+
+// Returns a transport for the plain HTTP clients (page scraping, CloudFront inspection,
+// bucket existence checks) that goes through -proxy and honours -insecure and -user-agent
+func newHTTPTransport() http.RoundTripper {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = proxyFunc()
+	skipVerify(tr)
+	return userAgentTransport{tr}
+}
+
+// Turns off certificate verification on the transport with -insecure
+func skipVerify(tr *http.Transport) {
+	if !insecureTLS {
+		return
+	}
+	if tr.TLSClientConfig == nil {
+		tr.TLSClientConfig = &tls.Config{}
+	}
+	tr.TLSClientConfig.InsecureSkipVerify = true
+}
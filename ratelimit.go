@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// Requests per second allowed across all goroutines, set from flags (0 means unlimited)
+var requestRate float64
+
+// A token bucket refilled at rate tokens per second, holding at most burst tokens
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// The limiter shared by every STS and S3 request, created on first use
+var (
+	limiter     *rateLimiter
+	limiterOnce sync.Once
+)
+
+// Creates a token bucket that allows up to a second's worth of requests in a burst
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Blocks until a token is available or the context is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Waits for the shared limiter before a request, if -rate was given
+func waitForRate(ctx context.Context) error {
+	if requestRate <= 0 {
+		return nil
+	}
+	limiterOnce.Do(func() {
+		limiter = newRateLimiter(requestRate)
+	})
+	return limiter.wait(ctx)
+}
+
+// Adds the shared limiter to every client built from the config. It sits after the
+// retry middleware, so retried attempts are limited as well.
+func applyRateLimit(cfg *aws.Config) {
+	if requestRate <= 0 {
+		return
+	}
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("RateLimit",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				if err := waitForRate(ctx); err != nil {
+					return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+				}
+				return next.HandleFinalize(ctx, in)
+			}), middleware.After)
+	})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+var bucketRegions = newSharedCache[string]() // Cache for storing bucket regions
+
+// File the looked-up bucket regions are persisted to across runs, set from flags ("" disables it)
+var regionCacheFile = defaultRegionCacheFile()
+
+var (
+	persistedRegions     map[string]string // Regions looked up in this and previous runs
+	persistedRegionsOnce sync.Once
+	persistedRegionsMu   sync.Mutex
+)
+
+// Commercial regions tried in turn when an access point alias' region can't be looked up directly
+var aliasSearchRegions = []string{
+	"us-east-1", "us-east-2", "us-west-1", "us-west-2", "ca-central-1", "ca-west-1",
+	"eu-west-1", "eu-west-2", "eu-west-3", "eu-central-1", "eu-central-2", "eu-north-1", "eu-south-1", "eu-south-2",
+	"ap-northeast-1", "ap-northeast-2", "ap-northeast-3", "ap-southeast-1", "ap-southeast-2", "ap-southeast-3", "ap-southeast-4",
+	"ap-south-1", "ap-south-2", "ap-east-1", "sa-east-1", "me-south-1", "me-central-1", "af-south-1", "il-central-1",
+}
+
+// Returns the bucket's region, checking the cache before querying
+func getBucketRegion(ctx context.Context, cfg aws.Config, creds aws.CredentialsProvider, bucket string) (string, error) {
+	persistedRegionsOnce.Do(loadRegionCache)
+	if region, found := bucketRegions.get(bucket); found {
+		return region, nil
+	}
+
+	// ARN targets carry their region and Multi-Region Access Points are signed for every
+	// region, so any of the partition's regions will do. Anything else has to be looked up.
+	region, ok := arnRegion(bucket)
+	if !ok && isMultiRegionAccessPoint(bucket) {
+		region, ok = currentPartition().defaultRegion, true
+	}
+	if !ok && isDirectoryBucket(bucket) {
+		// Directory buckets name their zone, and GetBucketRegion doesn't work against them
+		region, ok = directoryBucketRegion(bucket)
+		if !ok {
+			return "", fmt.Errorf("failed to get bucket region: unrecognised zone in directory bucket name %s", bucket)
+		}
+	}
+	if !ok {
+		// S3 names the region on unauthenticated requests too, so try that before
+		// spending a lookup with the assumed role
+		if region, ok = unauthenticatedBucketRegion(ctx, cfg, bucket); ok {
+			persistRegion(bucket, region)
+		}
+	}
+	if !ok {
+		// Ask the default S3 region, with the assumed role's credentials
+		s3Svc := s3ClientFor(cfg, currentPartition().defaultRegion, "")
+
+		// Get the bucket region
+		var err error
+		region, err = manager.GetBucketRegion(ctx, s3Svc, bucket, withCredentials(creds))
+		if err != nil && isAccessPointAlias(bucket) {
+			// Aliases resolve through their access point rather than a bucket, so fall back to asking each region
+			region, err = searchAliasRegion(ctx, cfg, creds, bucket)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to get bucket region: %w", err)
+		}
+		persistRegion(bucket, region)
+	}
+	bucketRegions.set(bucket, region)
+	return region, nil
+}
+
+// Returns ~/.s3accountfinder/regions.json, or "" if there's no home directory
+func defaultRegionCacheFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".s3accountfinder", "regions.json")
+}
+
+// Loads the regions persisted by previous runs into the cache. A missing or
+// unreadable file just means starting with an empty cache.
+func loadRegionCache() {
+	persistedRegions = make(map[string]string)
+	if regionCacheFile == "" {
+		return
+	}
+	data, err := os.ReadFile(regionCacheFile)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &persistedRegions); err != nil {
+		slog.Warn("Ignoring unreadable region cache", "file", regionCacheFile, "err", err)
+		persistedRegions = make(map[string]string)
+		return
+	}
+	for bucket, region := range persistedRegions {
+		bucketRegions.setDefault(bucket, region)
+	}
+}
+
+// Adds a looked-up region to the region cache file
+func persistRegion(bucket, region string) {
+	if regionCacheFile == "" {
+		return
+	}
+	persistedRegionsMu.Lock()
+	defer persistedRegionsMu.Unlock()
+	persistedRegions[bucket] = region
+
+	data, err := json.MarshalIndent(persistedRegions, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(regionCacheFile), 0o700)
+	}
+	if err == nil {
+		err = os.WriteFile(regionCacheFile, data, 0o600)
+	}
+	if err != nil {
+		slog.Error("Failed to save region cache", "err", err)
+	}
+}
+
+// Reads the x-amz-bucket-region header from an unsigned HEAD request to the bucket, which
+// S3 returns whether or not the request is allowed. Needs no credentials and works even
+// when the role can't call HeadBucket.
+func unauthenticatedBucketRegion(ctx context.Context, cfg aws.Config, bucket string) (string, bool) {
+	resp, err := anonymousHead(ctx, cfg, bucket)
+	if err != nil {
+		return "", false
+	}
+	region := resp.Header.Get("X-Amz-Bucket-Region")
+	return region, region != ""
+}
+
+// Sends an unsigned HEAD request to the bucket, returning the response with its body closed
+func anonymousHead(ctx context.Context, cfg aws.Config, bucket string) (*http.Response, error) {
+	endpoint := "https://" + bucket + "." + s3Endpoint()
+	if pathStyle || s3EndpointURL != "" || strings.Contains(bucket, ".") {
+		// Dotted names don't match the wildcard certificate
+		endpoint = s3BaseURL() + "/" + url.PathEscape(bucket)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	setUserAgent(req)
+
+	var client aws.HTTPClient = http.DefaultClient
+	if cfg.HTTPClient != nil {
+		client = cfg.HTTPClient
+	}
+	if err := waitForRate(ctx); err != nil {
+		return nil, err
+	}
+	if err := chargeAPICall(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	auditHTTP("S3", "HeadBucket", req, resp, start, err)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// Finds the region of an access point alias by issuing HeadBucket in each region until one doesn't redirect
+func searchAliasRegion(ctx context.Context, cfg aws.Config, creds aws.CredentialsProvider, alias string) (string, error) {
+	for _, region := range currentPartition().regions {
+		s3Svc := s3ClientFor(cfg, region, "")
+		_, err := s3Svc.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(alias)}, withCredentials(creds))
+		if err == nil {
+			return region, nil
+		}
+		var apiErr smithy.APIError
+		if !errors.As(err, &apiErr) {
+			return "", err
+		}
+		switch apiErr.ErrorCode() {
+		case "301", "PermanentRedirect", "AuthorizationHeaderMalformed", "400", "BadRequest":
+			continue
+		}
+		// Any other answer (403, 404, ...) came from the right region
+		return region, nil
+	}
+	return "", errors.New("access point alias was not found in any region")
+}
+
+// Matches the expected region in AuthorizationHeaderMalformed messages
+var expectedRegionRegexp = regexp.MustCompile(`expecting '([a-z0-9-]+)'`)
+
+// Returns the region a redirect or wrong-region error says the bucket is really in,
+// from the x-amz-bucket-region header or the AuthorizationHeaderMalformed message
+func redirectRegion(err error) (string, bool) {
+	var apiErr smithy.APIError
+	if err == nil || !errors.As(err, &apiErr) {
+		return "", false
+	}
+	switch apiErr.ErrorCode() {
+	case "301", "PermanentRedirect", "AuthorizationHeaderMalformed", "400", "IllegalLocationConstraintException":
+	default:
+		return "", false
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		if region := respErr.Response.Header.Get("X-Amz-Bucket-Region"); region != "" {
+			return region, true
+		}
+	}
+	if m := expectedRegionRegexp.FindStringSubmatch(apiErr.ErrorMessage()); m != nil {
+		return m[1], true
+	}
+	return "", false
+}
+
+// Region codes used in availability zone IDs, as found in directory bucket names
+var zoneIDRegions = map[string]string{
+	"use1": "us-east-1", "use2": "us-east-2", "usw1": "us-west-1", "usw2": "us-west-2",
+	"cac1": "ca-central-1", "caw1": "ca-west-1", "sae1": "sa-east-1",
+	"euw1": "eu-west-1", "euw2": "eu-west-2", "euw3": "eu-west-3", "euc1": "eu-central-1", "euc2": "eu-central-2",
+	"eun1": "eu-north-1", "eus1": "eu-south-1", "eus2": "eu-south-2",
+	"apne1": "ap-northeast-1", "apne2": "ap-northeast-2", "apne3": "ap-northeast-3",
+	"apse1": "ap-southeast-1", "apse2": "ap-southeast-2", "apse3": "ap-southeast-3", "apse4": "ap-southeast-4",
+	"aps1": "ap-south-1", "aps2": "ap-south-2", "ape1": "ap-east-1",
+	"mes1": "me-south-1", "mec1": "me-central-1", "afs1": "af-south-1", "ilc1": "il-central-1",
+}
+
+// Derives the region from a directory bucket name (base-name--usw2-az1--x-s3)
+func directoryBucketRegion(bucket string) (string, bool) {
+	parts := strings.Split(strings.TrimSuffix(bucket, "--x-s3"), "--")
+	if len(parts) < 2 {
+		return "", false
+	}
+	zoneID := parts[len(parts)-1]
+	region, ok := zoneIDRegions[strings.SplitN(zoneID, "-", 2)[0]]
+	return region, ok
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Turns results files written with -output (JSON lines or CSV) into a Markdown or HTML
+// report for a pentest deliverable
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	format := fs.String("format", "", "report format: markdown or html (default: html for an -o ending in .html, or else markdown)")
+	out := fs.String("o", "", "file to write the report to (default: stdout)")
+	title := fs.String("title", "S3 bucket owner enumeration", "report title")
+	addLogFlags(fs)
+	parseFlags(fs, args)
+	initLogging()
+
+	if fs.NArg() == 0 {
+		fatalConfigf("usage: report [-format markdown|html] [-o <file>] [-title <title>] <results file>...")
+	}
+	if *format == "" {
+		*format = "markdown"
+		if ext := strings.ToLower(filepath.Ext(*out)); ext == ".html" || ext == ".htm" {
+			*format = "html"
+		}
+	}
+	if *format != "markdown" && *format != "html" {
+		fatalConfigf("unknown format %q, expected markdown or html", *format)
+	}
+
+	var results []runResult
+	for _, file := range fs.Args() {
+		rs, err := readResults(file)
+		if err != nil {
+			fatalConfigf("failed to read %s: %v", file, err)
+		}
+		results = append(results, rs...)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fatalConfigf("failed to create report: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	data := newReportData(*title, results)
+	var err error
+	if *format == "html" {
+		err = htmlReportTemplate.Execute(w, data)
+	} else {
+		err = markdownReportTemplate.Execute(w, data)
+	}
+	if err != nil {
+		fatalConfigf("failed to write report: %v", err)
+	}
+}
+
+// Reads the results in a file written with -output: CSV if it has a .csv extension,
+// or else one JSON object per line
+func readResults(path string) ([]runResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return readCSVResults(f)
+	}
+
+	var results []runResult
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var r runResult
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		results = append(results, r)
+	}
+	return results, scanner.Err()
+}
+
+// Reads CSV results, matching columns by the header so that files written by older
+// versions with fewer columns still read
+func readCSVResults(r io.Reader) ([]runResult, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	column := make(map[string]int)
+	for i, name := range rows[0] {
+		column[name] = i
+	}
+	get := func(row []string, name string) string {
+		if i, ok := column[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	var results []runResult
+	for _, row := range rows[1:] {
+		r := runResult{
+			Path:          get(row, "path"),
+			Label:         get(row, "label"),
+			Bucket:        get(row, "bucket"),
+			Key:           get(row, "key"),
+			AccountID:     get(row, "account_id"),
+			Region:        get(row, "region"),
+			Status:        get(row, "status"),
+			Error:         get(row, "error"),
+			CallerAccount: get(row, "caller_account"),
+			CallerARN:     get(row, "caller_arn"),
+			Owner:         get(row, "owner"),
+			OrgAccount:    get(row, "org_account_name"),
+			OrgEmail:      get(row, "org_account_email"),
+		}
+		r.DigitsFound, _ = strconv.Atoi(get(row, "digits_found"))
+		r.Duration, _ = strconv.ParseFloat(get(row, "duration"), 64)
+		r.APICalls, _ = strconv.ParseInt(get(row, "api_calls"), 10, 64)
+		if same, err := strconv.ParseBool(get(row, "same_org")); err == nil {
+			r.SameOrg = &same
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// Everything a report shows, worked out from the results
+type reportData struct {
+	Title       string
+	Generated   string
+	Results     []runResult
+	Owners      []reportOwner // Accounts owning buckets, with the buckets each owns
+	Found       int           // Complete account IDs, confirmed or not
+	Confirmed   int
+	Partial     int
+	Errors      []runResult
+	TotalTime   string
+	AverageTime string
+	Slowest     *runResult
+}
+
+// An account and the buckets found to belong to it
+type reportOwner struct {
+	AccountID string
+	Name      string // Known owner of the account, if any
+	Buckets   []string
+}
+
+// Summarises the results for a report
+func newReportData(title string, results []runResult) reportData {
+	d := reportData{
+		Title:     title,
+		Generated: time.Now().UTC().Format("2006-01-02 15:04 UTC"),
+		Results:   results,
+	}
+	owners := make(map[string][]string)
+	names := make(map[string]string)
+	var total float64
+	for i, r := range results {
+		total += r.Duration
+		if d.Slowest == nil || r.Duration > d.Slowest.Duration {
+			d.Slowest = &results[i]
+		}
+		switch r.Status {
+		case statusConfirmed:
+			d.Confirmed++
+			fallthrough
+		case statusUnconfirmed, "complete":
+			d.Found++
+			owners[r.AccountID] = append(owners[r.AccountID], r.Bucket)
+			if name := r.ownerName(); name != "" {
+				names[r.AccountID] = name
+			}
+		case "partial":
+			d.Partial++
+		case "error":
+			d.Errors = append(d.Errors, r)
+		}
+	}
+	for id, buckets := range owners {
+		name, ok := names[id]
+		if !ok {
+			name = vendorName(id) // Results written before owners were reported
+		}
+		d.Owners = append(d.Owners, reportOwner{AccountID: id, Name: name, Buckets: buckets})
+	}
+	sort.Slice(d.Owners, func(i, j int) bool {
+		if len(d.Owners[i].Buckets) != len(d.Owners[j].Buckets) {
+			return len(d.Owners[i].Buckets) > len(d.Owners[j].Buckets)
+		}
+		return d.Owners[i].AccountID < d.Owners[j].AccountID
+	})
+	d.TotalTime = formatSeconds(total)
+	if len(results) > 0 {
+		d.AverageTime = formatSeconds(total / float64(len(results)))
+	}
+	return d
+}
+
+// Formats a duration in seconds for a report, e.g. 1m23s
+func formatSeconds(s float64) string {
+	return (time.Duration(s * float64(time.Second))).Round(time.Second).String()
+}
+
+// Returns the name a result is reported under
+func (r runResult) Target() string {
+	switch {
+	case r.Label != "":
+		return r.Label + " (" + r.Bucket + ")"
+	case r.Path != "" && r.Path != r.Bucket:
+		return r.Path + " (" + r.Bucket + ")"
+	}
+	return r.Bucket
+}
+
+// Returns how long the search took, formatted for a report
+func (r runResult) Took() string {
+	return formatSeconds(r.Duration)
+}
+
+// Escapes text for a Markdown table cell
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+// How the results were obtained, for the methodology section of both formats
+const reportMethodology = `The owning AWS account of each bucket was enumerated without any access to that account. ` +
+	`A role in the assessor's account with S3 read permissions was assumed with a session policy allowing access only ` +
+	`when the bucket's account (the s3:ResourceAccount condition key) starts with a given prefix. Whether the probe ` +
+	`request was allowed then reveals whether the prefix is correct, and the twelve digits are found one at a time. ` +
+	`Complete account IDs were confirmed with an exact match where enabled. No object data was read beyond the ` +
+	`single probe request per test, and the only records in the bucket owner's account are S3 access and CloudTrail ` +
+	`data events, if enabled.`
+
+var markdownReportTemplate = template.Must(template.New("markdown").Funcs(template.FuncMap{
+	"cell": markdownCell,
+}).Parse(`# {{.Title}}
+
+Generated {{.Generated}}.
+
+## Summary
+
+- Targets: {{len .Results}}
+- Owning account found: {{.Found}} ({{.Confirmed}} confirmed)
+- Partial account ID prefix: {{.Partial}}
+- Errors: {{len .Errors}}
+- Distinct owning accounts: {{len .Owners}}
+
+## Findings
+
+| Target | Account ID | Status | Region | Digits | Time |
+| --- | --- | --- | --- | --- | --- |
+{{range .Results}}| {{cell .Target}} | {{if .AccountID}}` + "`{{.AccountID}}`" + `{{end}} | {{.Status}} | {{.Region}} | {{.DigitsFound}} | {{.Took}} |
+{{end}}{{if .Owners}}
+## Owning accounts
+
+{{range .Owners}}- ` + "`{{.AccountID}}`" + `{{if .Name}} ({{cell .Name}}){{end}}: {{range $i, $b := .Buckets}}{{if $i}}, {{end}}{{cell $b}}{{end}}
+{{end}}{{end}}{{if .Errors}}
+## Errors
+
+{{range .Errors}}- {{cell .Target}}: {{cell .Error}}
+{{end}}{{end}}
+## Timing
+
+- Total search time: {{.TotalTime}}{{if .AverageTime}}
+- Average per target: {{.AverageTime}}{{end}}{{if .Slowest}}
+- Slowest target: {{cell .Slowest.Target}} ({{.Slowest.Took}}){{end}}
+
+## Methodology
+
+` + reportMethodology + `
+`))
+
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+th { background: #f0f0f0; }
+code { font-size: 1.05em; }
+.confirmed { color: #1a7f37; } .unconfirmed, .partial { color: #9a6700; } .error { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{.Generated}}.</p>
+
+<h2>Summary</h2>
+<ul>
+<li>Targets: {{len .Results}}</li>
+<li>Owning account found: {{.Found}} ({{.Confirmed}} confirmed)</li>
+<li>Partial account ID prefix: {{.Partial}}</li>
+<li>Errors: {{len .Errors}}</li>
+<li>Distinct owning accounts: {{len .Owners}}</li>
+</ul>
+
+<h2>Findings</h2>
+<table>
+<tr><th>Target</th><th>Account ID</th><th>Status</th><th>Region</th><th>Digits</th><th>Time</th></tr>
+{{range .Results}}<tr><td>{{.Target}}</td><td><code>{{.AccountID}}</code></td><td class="{{.Status}}">{{.Status}}</td><td>{{.Region}}</td><td>{{.DigitsFound}}</td><td>{{.Took}}</td></tr>
+{{end}}</table>
+{{if .Owners}}
+<h2>Owning accounts</h2>
+<ul>
+{{range .Owners}}<li><code>{{.AccountID}}</code>{{if .Name}} ({{.Name}}){{end}}: {{range $i, $b := .Buckets}}{{if $i}}, {{end}}{{$b}}{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .Errors}}
+<h2>Errors</h2>
+<ul>
+{{range .Errors}}<li>{{.Target}}: {{.Error}}</li>
+{{end}}</ul>
+{{end}}
+<h2>Timing</h2>
+<ul>
+<li>Total search time: {{.TotalTime}}</li>{{if .AverageTime}}
+<li>Average per target: {{.AverageTime}}</li>{{end}}{{if .Slowest}}
+<li>Slowest target: {{.Slowest.Target}} ({{.Slowest.Took}})</li>{{end}}
+</ul>
+
+<h2>Methodology</h2>
+<p>` + reportMethodology + `</p>
+</body>
+</html>
+`))
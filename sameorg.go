@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Whether to check if each bucket is in the caller's organization before searching, and
+// the organization's ID, set from flags (looked up if not given)
+var (
+	sameOrgCheck bool
+	sameOrgID    string
+)
+
+// Format of an organization ID
+var orgIDPattern = regexp.MustCompile(`^o-[a-z0-9]{10,32}$`)
+
+// Outcomes of the same-organization check by bucket
+var sameOrgResults = newSharedCache[bool]()
+
+// Looks up the caller's organization ID for -same-org unless -org-id gave it. Failing
+// turns the check off rather than stopping the run.
+func initSameOrg(ctx context.Context, cfg aws.Config) {
+	if !sameOrgCheck || sameOrgID != "" {
+		return
+	}
+	id, err := describeOrgID(ctx, cfg)
+	if err != nil {
+		slog.Warn("Failed to look up your organization, skipping the same-organization check (use -org-id to give it)", "err", err)
+		sameOrgCheck = false
+		return
+	}
+	sameOrgID = id
+	slog.Debug("Caller organization", "org_id", sameOrgID)
+}
+
+// Constructs a policy allowing access to resources in the organization
+func orgPolicy(bucket, orgID string) map[string]interface{} {
+	return sessionPolicy(map[string]interface{}{
+		"Sid":      "AllowResourceOrg",
+		"Effect":   "Allow",
+		"Action":   probeActions(bucket),
+		"Resource": policyResources(bucket),
+		"Condition": map[string]interface{}{
+			"StringEquals": map[string]interface{}{
+				"aws:ResourceOrgID": orgID,
+			},
+		},
+	})
+}
+
+// Answers "is this bucket ours?" with a single probe allowing access only to resources
+// in the caller's organization, before the digit search. The outcome is kept for the
+// bucket's result.
+func checkSameOrg(ctx context.Context, cfg aws.Config, bucket, key, roleArn string) error {
+	if !sameOrgCheck {
+		return nil
+	}
+	if _, ok := sameOrgResults.get(bucket); ok {
+		return nil
+	}
+	same, err := canAccessWithPolicy(withEvidenceStep(ctx, "same-org", 0), cfg, bucket, key, roleArn, orgPolicy(bucket, sameOrgID))
+	if err != nil {
+		return err
+	}
+	sameOrgResults.set(bucket, same)
+	if same {
+		progressPrintf(colorGreen, "%s is in your organization (%s)\n", bucket, sameOrgID)
+	} else {
+		progressPrintf(colorYellow, "%s is not in your organization (%s)\n", bucket, sameOrgID)
+	}
+	return nil
+}
+
+// Returns the outcome of the same-organization check of the bucket, or nil if it wasn't
+// checked
+func sameOrgFor(bucket string) *bool {
+	same, ok := sameOrgResults.get(bucket)
+	if !ok {
+		return nil
+	}
+	return &same
+}
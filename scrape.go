@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// s3:// URIs and http(s) or protocol-relative URLs on an amazonaws.com host
+	s3URLRegexp = regexp.MustCompile(`(?i)(?:s3://[a-z0-9][a-z0-9.\-]*|(?:https?:)?//[a-z0-9][a-z0-9.\-]*\.amazonaws\.com(?:\.cn)?)[^\s"'<>\\)\]]*`)
+	// Bare virtual-hosted-style hostnames, e.g. in JS string concatenation
+	s3HostRegexp = regexp.MustCompile(`(?i)\b[a-z0-9][a-z0-9.\-]*\.s3[a-z0-9.\-]*\.amazonaws\.com(?:\.cn)?\b`)
+	// External scripts referenced from a page
+	scriptSrcRegexp = regexp.MustCompile(`(?i)<script[^>]+src\s*=\s*["']([^"']+)["']`)
+)
+
+// Largest response body read from a scraped page or script
+const maxScrapeBytes = 10 << 20
+
+// Fetches pages and their scripts, extracts S3 bucket references and enumerates their owners
+func runScrape(args []string) {
+	fs := flag.NewFlagSet("scrape", flag.ContinueOnError)
+	list := fs.String("list", "", "file of URLs to scrape, one per line")
+	roleArn := fs.String("role_arn", "", "ARN of the role to assume; if omitted, found targets are only listed")
+	addRequestFlags(fs)
+	addLogFlags(fs)
+	parseFlags(fs, args)
+	initLogging()
+
+	pages := fs.Args()
+	if *list != "" {
+		listed, err := readWordlist(*list)
+		if err != nil {
+			fatalConfigf("failed to read URL list: %v", err)
+		}
+		pages = append(pages, listed...)
+	}
+	if len(pages) == 0 {
+		fatalConfigf("usage: scrape [-role_arn <role_arn>] [-list <file>] <url>...")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second, Transport: newHTTPTransport()}
+	var targets []target
+	seen := make(map[string]bool)
+	for _, page := range pages {
+		for _, ref := range scrapePage(client, page) {
+			// One probe target per bucket is enough, keep the first key seen for it
+			bucket, _, err := toS3Args(ref)
+			if err != nil || seen[bucket] {
+				continue
+			}
+			seen[bucket] = true
+			fmt.Printf("found: %s\n", ref)
+			targets = append(targets, target{Path: ref})
+		}
+	}
+
+	runFoundTargets(targets, *roleArn)
+}
+
+// Returns the S3 references in a page and in the scripts it loads
+func scrapePage(client *http.Client, page string) []string {
+	base, err := url.Parse(page)
+	if err != nil {
+		slog.Warn("Skipping page", "url", page, "err", err)
+		return nil
+	}
+	body, err := fetch(client, page)
+	if err != nil {
+		slog.Error("Failed to fetch", "url", page, "err", err)
+		return nil
+	}
+
+	refs := extractS3References(body)
+	for _, match := range scriptSrcRegexp.FindAllStringSubmatch(body, -1) {
+		src, err := base.Parse(match[1])
+		if err != nil {
+			continue
+		}
+		script, err := fetch(client, src.String())
+		if err != nil {
+			slog.Error("Failed to fetch", "url", src.String(), "err", err)
+			continue
+		}
+		refs = append(refs, extractS3References(script)...)
+	}
+	return refs
+}
+
+// Fetches a URL's body, up to maxScrapeBytes
+func fetch(client *http.Client, u string) (string, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxScrapeBytes))
+	return string(body), err
+}
+
+// Extracts s3:// URIs, S3 URLs (presigned ones included) and bare S3 hostnames from HTML or JS
+func extractS3References(body string) []string {
+	var refs []string
+	for _, match := range s3URLRegexp.FindAllString(body, -1) {
+		if strings.HasPrefix(match, "//") {
+			match = "https:" + match
+		}
+		if strings.HasPrefix(strings.ToLower(match), "s3://") {
+			refs = append(refs, match)
+			continue
+		}
+		if u, err := url.Parse(match); err == nil && isS3Host(strings.ToLower(u.Hostname())) {
+			refs = append(refs, match)
+		}
+	}
+	for _, host := range s3HostRegexp.FindAllString(body, -1) {
+		if isS3Host(strings.ToLower(host)) {
+			refs = append(refs, "https://"+host+"/")
+		}
+	}
+	return refs
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractS3References(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       []string
+	}{
+		{"none", `<a href="https://example.com/s3">s3</a>`, nil},
+		{"uri", `copy s3://assets-bucket/build.zip now`, []string{"s3://assets-bucket/build.zip"}},
+		{"path style", `<img src="https://s3.us-east-2.amazonaws.com/img-bucket/logo.png">`, []string{"https://s3.us-east-2.amazonaws.com/img-bucket/logo.png"}},
+		{"protocol relative", `<script src='//s3.amazonaws.com/js-bucket/app.js'></script>`, []string{"https://s3.amazonaws.com/js-bucket/app.js"}},
+		{"presigned", `"https://s3.amazonaws.com/b/k?X-Amz-Credential=AKIA%2F20240101%2Fus-west-2%2Fs3%2Faws4_request"`, []string{"https://s3.amazonaws.com/b/k?X-Amz-Credential=AKIA%2F20240101%2Fus-west-2%2Fs3%2Faws4_request"}},
+		{"virtual hosted", `url(https://bg-bucket.s3.amazonaws.com/bg.png)`, []string{"https://bg-bucket.s3.amazonaws.com/bg.png", "https://bg-bucket.s3.amazonaws.com/"}},
+		{"not s3", `fetch("https://dynamodb.us-east-1.amazonaws.com/")`, nil},
+		{"bare host", `var base = "cdn-bucket.s3-eu-west-1" + ".amazonaws.com"; var h = "media.s3.amazonaws.com";`, []string{"https://media.s3.amazonaws.com/"}},
+	}
+	for _, tt := range tests {
+		if got := extractS3References(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: extractS3References() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+var possibleDigits = []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+
+// Finds the digit following prefix, or "" if none can be determined
+type digitFinder func(ctx context.Context, cfg aws.Config, bucket, key, roleArn, prefix string) (string, error)
+
+// Search strategies selectable with -strategy, trading speed against API call volume
+var digitFinders = map[string]digitFinder{
+	"sequential": findNextDigitSequentially,
+	"parallel":   findNextDigitConcurrently,
+	"bisect":     findNextDigitBisect,
+}
+
+// Strategy used to find each digit, set from flags
+var searchStrategy = "bisect"
+
+// Number of digits probed at once by the parallel strategy, set from flags
+var digitWorkers = 10
+
+// Number of digits to find before stopping, set from flags. Anything short of 12
+// reports a partial prefix, which is often enough to match a suspected owner.
+var maxDigits = 12
+
+// Searches for the account ID one digit at a time, starting after any already known leading
+// digits. The digits found so far are returned even when a digit can't be determined or
+// a probe fails, along with the error in the latter case. A complete account ID comes
+// with its confirmation status.
+func searchAccountID(ctx context.Context, cfg aws.Config, bucket, key, roleArn, knownDigits string) (string, string, error) {
+	accountID := knownDigits
+	rejected := make(map[string]bool)
+	for len(accountID) < maxDigits {
+		digitStart := time.Now()
+		startDigitStat(bucket)
+		match, ok, err := confirmKnownAccount(withEvidenceStep(ctx, "known-account", len(accountID)), cfg, bucket, key, roleArn, accountID, rejected)
+		if err != nil {
+			return accountID, "", err
+		}
+		if ok {
+			progressPrintf(colorGreen, "Prefix %s uniquely matches known account %s, confirmed\n", accountID, match.name())
+			recordProgress(bucket, match.ID)
+			recordOwner(bucket, match.ID, "known-accounts")
+			return match.ID, statusConfirmed, nil
+		}
+		nextDigit, err := digitFinders[searchStrategy](withEvidenceStep(ctx, "digit", len(accountID)+1), cfg, bucket, key, roleArn, accountID)
+		if err != nil {
+			return accountID, "", err
+		}
+		if nextDigit == "" {
+			slog.Warn("Could not find the next digit of the account ID", "bucket", bucket, "prefix", accountID)
+			break
+		}
+		accountID += nextDigit
+		progressPrintf(colorCyan, "Found digits so far: %s\n", accountID)
+		emitEvent(progressEvent{Event: "digit_found", Bucket: bucket, Prefix: accountID, Position: len(accountID), Duration: time.Since(digitStart).Seconds()})
+		updateProgress(bucket, accountID)
+		recordProgress(bucket, accountID)
+		recordDigit(bucket, accountID, time.Since(digitStart))
+		recordDigitStat(bucket, len(accountID), time.Since(digitStart))
+	}
+	if len(accountID) < 12 {
+		return accountID, "", nil
+	}
+
+	status, err := confirmAccountID(ctx, cfg, bucket, key, roleArn, accountID)
+	if err != nil {
+		return accountID, "", err
+	}
+	if status != statusUnconfirmed {
+		recordOwner(bucket, accountID, "enumeration ("+searchStrategy+")")
+	}
+	return accountID, status, nil
+}
+
+// Confirmation status of a complete account ID
+const (
+	statusConfirmed   = "confirmed"
+	statusUnconfirmed = "unconfirmed"
+)
+
+// Number of StringEquals probes confirming an enumerated account ID, set from flags
+var confirmations = 1
+
+// Whether to cross-check the account ID with ExpectedBucketOwner, set from flags
+var crossCheck bool
+
+// Probes the complete account ID with StringEquals, confirmations times, to guard against
+// a flaky probe having corrupted a digit, then cross-checks it with ExpectedBucketOwner if
+// enabled. Returns "" if neither check is enabled.
+func confirmAccountID(ctx context.Context, cfg aws.Config, bucket, key, roleArn, accountID string) (string, error) {
+	if confirmations <= 0 && !crossCheck {
+		return "", nil
+	}
+	for i := 0; i < confirmations; i++ {
+		allowed, err := canAccessWithPolicy(withEvidenceStep(ctx, "confirm", 0), cfg, bucket, key, roleArn, getExactPolicy(bucket, []string{accountID}))
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			slog.Warn("Account ID failed a confirmation probe", "account_id", accountID, "probe", i+1, "of", confirmations)
+			return statusUnconfirmed, nil
+		}
+	}
+	if crossCheck {
+		agrees, err := crossCheckOwner(withEvidenceStep(ctx, "cross-check", 0), cfg, bucket, key, roleArn, accountID)
+		if err != nil {
+			return "", err
+		}
+		if !agrees {
+			slog.Warn("ExpectedBucketOwner cross-check disagrees", "account_id", accountID, "bucket", bucket)
+			return statusUnconfirmed, nil
+		}
+		colorPrintf(os.Stdout, colorGreen, "ExpectedBucketOwner cross-check agrees: %s owns %s\n", accountID, bucket)
+	}
+	return statusConfirmed, nil
+}
+
+// Repeats the unrestricted probe with ExpectedBucketOwner set to the account ID. S3 denies
+// the request if the bucket has a different owner, independently of the session policy
+// technique used to find the ID.
+func crossCheckOwner(ctx context.Context, cfg aws.Config, bucket, key, roleArn, accountID string) (bool, error) {
+	if isOutpostsBucketARN(bucket) {
+		return false, errors.New("ExpectedBucketOwner cross-check isn't supported for Outposts buckets")
+	}
+	return canAccessWithPolicy(ctx, cfg, bucket, key, roleArn, nil, withExpectedBucketOwner(accountID))
+}
+
+// Formats a confirmation status for appending to a result
+func statusSuffix(status string) string {
+	if status == "" {
+		return ""
+	}
+	return " (" + status + ")"
+}
+
+// Checks whether the prefix matches exactly one known account and, if so, confirms it
+// with a single exact-match probe. Accounts that fail confirmation are remembered so
+// that longer prefixes don't probe them again.
+func confirmKnownAccount(ctx context.Context, cfg aws.Config, bucket, key, roleArn, prefix string, rejected map[string]bool) (knownAccount, bool, error) {
+	if len(knownAccounts) == 0 || len(prefix) == 0 {
+		return knownAccount{}, false, nil
+	}
+	matches := matchKnownAccounts(prefix)
+	if len(matches) != 1 || rejected[matches[0].ID] {
+		return knownAccount{}, false, nil
+	}
+	allowed, err := canAccessWithPolicy(ctx, cfg, bucket, key, roleArn, getExactPolicy(bucket, []string{matches[0].ID}))
+	if err != nil || !allowed {
+		rejected[matches[0].ID] = err == nil
+		return knownAccount{}, false, err
+	}
+	return matches[0], true, nil
+}
+
+// Finds the next digit by testing one digit at a time, stopping at the first hit.
+// The slowest strategy, but it never has more than one probe in flight.
+func findNextDigitSequentially(ctx context.Context, cfg aws.Config, bucket, key, roleArn, prefix string) (string, error) {
+	for _, digit := range remainingDigits(bucket, prefix) {
+		allowed, err := canAccessWithPolicy(ctx, cfg, bucket, key, roleArn, getPolicy(bucket, digitPrefixes(prefix, []string{digit})))
+		if skipInconclusive(err, "digit "+digit+" after "+prefix) {
+			// Not ruled out, so a resumed run tries it again
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if allowed {
+			return digit, nil
+		}
+		recordRuledOut(ctx, bucket, prefix, []string{digit})
+	}
+	return "", nil
+}
+
+// Carries the context shared by the probes findNextDigitConcurrently races, so a probe
+// cancelled because a sibling found the digit can be told from one cancelled with the run
+type siblingParentKey struct{}
+
+// Finds the next digit concurrently with a pool of digitWorkers goroutines, cancelling
+// the outstanding AssumeRole and probe calls as soon as one digit is confirmed or a
+// probe fails
+func findNextDigitConcurrently(ctx context.Context, cfg aws.Config, bucket, key, roleArn, prefix string) (string, error) {
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, siblingParentKey{}, ctx))
+	defer cancel()
+
+	remaining := remainingDigits(bucket, prefix)
+	var once sync.Once
+	var nextDigit string
+	var firstErr error
+	runPool(ctx, digitWorkers, len(remaining), func(ctx context.Context, i int) {
+		digit := remaining[i]
+		policy := getPolicy(bucket, []string{prefix + digit + "*"})
+		allowed, err := canAccessWithPolicy(ctx, cfg, bucket, key, roleArn, policy)
+		if skipInconclusive(err, "digit "+digit+" after "+prefix) {
+			return
+		}
+		if err != nil || allowed {
+			once.Do(func() {
+				if err != nil {
+					firstErr = err
+				} else {
+					nextDigit = digit
+				}
+				cancel()
+			})
+			return
+		}
+		recordRuledOut(ctx, bucket, prefix, []string{digit})
+	})
+	return nextDigit, firstErr
+}
+
+// Finds the next digit by bisecting the candidate digits. Each probe allows half of the
+// remaining candidates at once (one StringLike prefix per digit), so a position takes
+// at most four probes instead of ten. A digit reached purely by elimination, without
+// any probe having been allowed, is confirmed with one more probe before it's trusted,
+// so a position where every probe is denied takes five.
+func findNextDigitBisect(ctx context.Context, cfg aws.Config, bucket, key, roleArn, prefix string) (string, error) {
+	candidates := remainingDigits(bucket, prefix)
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	confirmed := false
+	for len(candidates) > 1 {
+		half, rest := candidates[:len(candidates)/2], candidates[len(candidates)/2:]
+		allowed, err := canAccessWithPolicy(ctx, cfg, bucket, key, roleArn, getPolicy(bucket, digitPrefixes(prefix, half)))
+		if skipInconclusive(err, "bisecting after "+prefix) {
+			// Nothing can be ruled out from this probe, so test the digits one at a time
+			return findNextDigitSequentially(ctx, cfg, bucket, key, roleArn, prefix)
+		}
+		if err != nil {
+			return "", err
+		}
+		if allowed {
+			recordRuledOut(ctx, bucket, prefix, rest)
+			candidates, confirmed = half, true
+		} else {
+			recordRuledOut(ctx, bucket, prefix, half)
+			candidates = rest
+		}
+	}
+
+	if !confirmed {
+		allowed, err := canAccessWithPolicy(ctx, cfg, bucket, key, roleArn, getPolicy(bucket, digitPrefixes(prefix, candidates)))
+		if skipInconclusive(err, "digit "+candidates[0]+" after "+prefix) {
+			return "", nil
+		}
+		if err != nil || !allowed {
+			return "", err
+		}
+	}
+	return candidates[0], nil
+}
+
+// Returns the candidates for the digit following prefix, leaving out any that an
+// interrupted run already ruled out
+func remainingDigits(bucket, prefix string) []string {
+	denied := ruledOutDigits(bucket, prefix)
+	var digits []string
+	for _, digit := range possibleDigits {
+		if !denied[digit] {
+			digits = append(digits, digit)
+		}
+	}
+	return digits
+}
+
+// Builds a StringLike pattern per digit for the account ID prefix
+func digitPrefixes(prefix string, digits []string) []string {
+	patterns := make([]string, len(digits))
+	for i, digit := range digits {
+		patterns[i] = prefix + digit + "*"
+	}
+	return patterns
+}
+
+// Checks that already known leading digits could start an account ID
+func validateKnownDigits(digits string) error {
+	if len(digits) > 12 {
+		return fmt.Errorf("known digits %q are longer than an account ID", digits)
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("known digits %q must only contain digits", digits)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+func TestRemainingDigitsSkipsRuledOut(t *testing.T) {
+	defer func(state *checkpointState, filename string) { checkpoint, checkpointFile = state, filename }(checkpoint, checkpointFile)
+	checkpoint = nil
+	if err := initCheckpoint(filepath.Join(t.TempDir(), "checkpoint.json"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	recordRuledOut(ctx, "bucket", "12", []string{"0", "1", "2", "3", "4"})
+	recordRuledOut(ctx, "bucket", "12", []string{"4", "7"})
+	tests := []struct {
+		bucket, prefix string
+		want           []string
+	}{
+		{"bucket", "12", []string{"5", "6", "8", "9"}},
+		{"bucket", "1", possibleDigits},
+		{"other", "12", possibleDigits},
+	}
+	for _, tt := range tests {
+		if got := remainingDigits(tt.bucket, tt.prefix); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("remainingDigits(%q, %q) = %q, want %q", tt.bucket, tt.prefix, got, tt.want)
+		}
+	}
+
+	// Finding the digit moves the search on, so what was ruled out no longer applies
+	recordProgress("bucket", "123")
+	if got := remainingDigits("bucket", "12"); !reflect.DeepEqual(got, possibleDigits) {
+		t.Errorf("remainingDigits() after progress = %q, want every digit", got)
+	}
+}
+
+// Starts fake STS and S3 endpoints for buckets owned by owner ("" for a bucket that
+// denies everything). AssumeRole hands out a session that S3 allows only when one of
+// the session policy's StringLike patterns matches the owner, as the ResourceAccount
+// condition would. Returns the configuration to probe with and the number of probes.
+func fakeAWS(t *testing.T, owner string) (aws.Config, *atomic.Int32) {
+	t.Helper()
+	var probes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			probes.Add(1)
+			r.ParseForm()
+			var policy struct {
+				Statement []struct {
+					Condition map[string]map[string][]string
+				}
+			}
+			json.Unmarshal([]byte(r.Form.Get("Policy")), &policy)
+			token := "deny"
+			for _, s := range policy.Statement {
+				for _, pattern := range s.Condition["StringLike"]["s3:ResourceAccount"] {
+					if owner != "" && strings.HasPrefix(owner, strings.TrimSuffix(pattern, "*")) {
+						token = "allow"
+					}
+				}
+			}
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials><AccessKeyId>AKIA</AccessKeyId><SecretAccessKey>s</SecretAccessKey><SessionToken>%s</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`, token)
+			return
+		}
+		if r.Header.Get("X-Amz-Security-Token") != "allow" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	oldSTS, oldS3, oldSTSClients, oldS3Clients, oldCache := stsEndpoint, s3EndpointURL, stsClients, s3Clients, regionCacheFile
+	t.Cleanup(func() {
+		stsEndpoint, s3EndpointURL, stsClients, s3Clients, regionCacheFile = oldSTS, oldS3, oldSTSClients, oldS3Clients, oldCache
+	})
+	stsEndpoint, s3EndpointURL, regionCacheFile = srv.URL, srv.URL, ""
+	stsClients, s3Clients = newSharedCache[*sts.Client](), newSharedCache[*s3.Client]()
+	bucketRegions.set("bucket", "us-east-1")
+
+	cfg := aws.Config{Region: "us-east-1", Credentials: credentials.NewStaticCredentialsProvider("AKIA", "s", "")}
+	return cfg, &probes
+}
+
+func TestFindNextDigitBisect(t *testing.T) {
+	tests := []struct {
+		name, owner, want string
+		probes            int32
+	}{
+		{"allowed first", "012345678901", "0", 3},
+		{"allowed in the upper half", "512345678901", "5", 3},
+		// Reached by elimination alone, so confirmed with one more probe
+		{"elimination confirmed", "912345678901", "9", 5},
+		{"elimination refuted", "", "", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, probes := fakeAWS(t, tt.owner)
+			got, err := findNextDigitBisect(context.Background(), cfg, "bucket", "", "arn:aws:iam::111122223333:role/r", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || probes.Load() != tt.probes {
+				t.Errorf("findNextDigitBisect() = %q after %d probes, want %q after %d", got, probes.Load(), tt.want, tt.probes)
+			}
+		})
+	}
+}
+
+func TestParseKnownAccounts(t *testing.T) {
+	input := `# vendor accounts
+111122223333 Example Corp
+444455556666,Another Vendor, Inc.
+777788889999	Tabbed
+111122223333 Duplicate
+
+000011112222
+`
+	want := []knownAccount{
+		{ID: "111122223333", Name: "Example Corp"},
+		{ID: "444455556666", Name: "Another Vendor, Inc."},
+		{ID: "777788889999", Name: "Tabbed"},
+		{ID: "000011112222"},
+	}
+	got, err := parseKnownAccounts(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKnownAccounts() = %+v, want %+v", got, want)
+	}
+
+	for _, line := range []string{"12345", "1111222233334", "11112222333a name"} {
+		if _, err := parseKnownAccounts(strings.NewReader(line)); err == nil {
+			t.Errorf("parseKnownAccounts(%q) succeeded, want an error", line)
+		}
+	}
+}
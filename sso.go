@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
+)
+
+// An SSO access token as cached in ~/.aws/sso/cache, where the SDK reads it from
+type ssoCachedToken struct {
+	AccessToken           string `json:"accessToken"`
+	ExpiresAt             string `json:"expiresAt"`
+	RefreshToken          string `json:"refreshToken,omitempty"`
+	ClientID              string `json:"clientId,omitempty"`
+	ClientSecret          string `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt string `json:"registrationExpiresAt,omitempty"`
+	Region                string `json:"region,omitempty"`
+	StartURL              string `json:"startUrl,omitempty"`
+}
+
+// Makes sure an IAM Identity Center (SSO) profile has a valid cached token, running
+// the device authorization flow, like aws sso login, if it's missing or expired.
+// Profiles that don't use SSO are left alone.
+func ensureSSOLogin(ctx context.Context, profile string) error {
+	if profile == "" && os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		// Environment credentials come before any profile
+		return nil
+	}
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	shared, err := config.LoadSharedConfigProfile(ctx, profile, func(o *config.LoadSharedConfigOptions) {
+		if awsConfigFile != "" {
+			o.ConfigFiles = []string{awsConfigFile}
+		}
+		if awsCredentialsFile != "" {
+			o.CredentialsFiles = []string{awsCredentialsFile}
+		}
+	})
+	if err != nil {
+		// No such profile, so the credentials come from somewhere else
+		return nil
+	}
+
+	// sso-session profiles cache the token under the session name, legacy ones under the start URL
+	startURL, region, cacheKey := shared.SSOStartURL, shared.SSORegion, shared.SSOStartURL
+	if shared.SSOSession != nil {
+		startURL, region, cacheKey = shared.SSOSession.SSOStartURL, shared.SSOSession.SSORegion, shared.SSOSession.Name
+	}
+	if startURL == "" {
+		return nil
+	}
+	cacheFile, err := ssocreds.StandardCachedTokenFilepath(cacheKey)
+	if err != nil {
+		return err
+	}
+	if ssoTokenValid(cacheFile) {
+		return nil
+	}
+
+	slog.Info("The SSO token is missing or expired, logging in", "profile", profile)
+	token, err := ssoDeviceLogin(ctx, region, startURL)
+	if err != nil {
+		return fmt.Errorf("SSO login failed: %w", err)
+	}
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(cacheFile), 0o700)
+	}
+	if err == nil {
+		err = os.WriteFile(cacheFile, data, 0o600)
+	}
+	return err
+}
+
+// Reports whether the cached token exists and is valid for at least another minute
+func ssoTokenValid(cacheFile string) bool {
+	data, err := os.ReadFile(cacheFile)
+	if err != nil {
+		return false
+	}
+	var token ssoCachedToken
+	if err := json.Unmarshal(data, &token); err != nil || token.AccessToken == "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	return err == nil && time.Until(expires) > time.Minute
+}
+
+// Runs the OIDC device authorization flow: registers a client, asks the user to approve
+// the login in a browser and polls until they have
+func ssoDeviceLogin(ctx context.Context, region, startURL string) (ssoCachedToken, error) {
+	// Built like the other clients, so the login goes through the proxy and TLS settings
+	// and is limited, charged and logged like every other call
+	cfg := aws.Config{Region: region}
+	if customHTTPClient() {
+		cfg.HTTPClient = sdkHTTPClient()
+	}
+	applyMiddlewares(&cfg)
+	client := ssooidc.NewFromConfig(cfg)
+	reg, err := client.RegisterClient(ctx, &ssooidc.RegisterClientInput{
+		ClientName: aws.String("S3AccountFinder"),
+		ClientType: aws.String("public"),
+	})
+	if err != nil {
+		return ssoCachedToken{}, err
+	}
+	auth, err := client.StartDeviceAuthorization(ctx, &ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     reg.ClientId,
+		ClientSecret: reg.ClientSecret,
+		StartUrl:     aws.String(startURL),
+	})
+	if err != nil {
+		return ssoCachedToken{}, err
+	}
+	fmt.Fprintf(os.Stderr, "Open %s and confirm the code %s\n", aws.ToString(auth.VerificationUriComplete), aws.ToString(auth.UserCode))
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ssoCachedToken{}, ctx.Err()
+		case <-time.After(interval):
+		}
+		out, err := client.CreateToken(ctx, &ssooidc.CreateTokenInput{
+			ClientId:     reg.ClientId,
+			ClientSecret: reg.ClientSecret,
+			DeviceCode:   auth.DeviceCode,
+			GrantType:    aws.String("urn:ietf:params:oauth:grant-type:device_code"),
+		})
+		var pending *types.AuthorizationPendingException
+		var slowDown *types.SlowDownException
+		if errors.As(err, &pending) {
+			continue
+		} else if errors.As(err, &slowDown) {
+			interval += 5 * time.Second
+			continue
+		} else if err != nil {
+			return ssoCachedToken{}, err
+		}
+		return ssoCachedToken{
+			AccessToken:           aws.ToString(out.AccessToken),
+			ExpiresAt:             time.Now().Add(time.Duration(out.ExpiresIn) * time.Second).UTC().Format(time.RFC3339),
+			RefreshToken:          aws.ToString(out.RefreshToken),
+			ClientID:              aws.ToString(reg.ClientId),
+			ClientSecret:          aws.ToString(reg.ClientSecret),
+			RegistrationExpiresAt: time.Unix(reg.ClientSecretExpiresAt, 0).UTC().Format(time.RFC3339),
+			Region:                region,
+			StartURL:              startURL,
+		}, nil
+	}
+	return ssoCachedToken{}, errors.New("the login wasn't approved in time")
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Whether to collect and print timing statistics, set from flags
+var collectStats bool
+
+// How long a digit position took to find, and the probes it took
+type digitStat struct {
+	position int
+	elapsed  time.Duration
+	probes   int64
+}
+
+// Timing statistics of a target
+type targetStat struct {
+	bucket      string
+	probes      int64 // Every probe, access checks and confirmation included
+	digitProbes int64 // Probes since the current digit's search started
+	digits      []digitStat
+	elapsed     time.Duration
+}
+
+var (
+	statsMu     sync.Mutex
+	targetStats = make(map[string]*targetStat)
+	statsOrder  []string // Buckets in the order they were first probed
+)
+
+// Returns the bucket's statistics, called with statsMu held
+func statFor(bucket string) *targetStat {
+	s, ok := targetStats[bucket]
+	if !ok {
+		s = &targetStat{bucket: bucket}
+		targetStats[bucket] = s
+		statsOrder = append(statsOrder, bucket)
+	}
+	return s
+}
+
+// Counts a probe of the bucket
+func countProbeStat(bucket string) {
+	if !collectStats {
+		return
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := statFor(bucket)
+	s.probes++
+	s.digitProbes++
+}
+
+// Marks the start of the search for the bucket's next digit
+func startDigitStat(bucket string) {
+	if !collectStats {
+		return
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	statFor(bucket).digitProbes = 0
+}
+
+// Records the digit found at position, which took elapsed
+func recordDigitStat(bucket string, position int, elapsed time.Duration) {
+	if !collectStats {
+		return
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := statFor(bucket)
+	s.digits = append(s.digits, digitStat{position, elapsed, s.digitProbes})
+}
+
+// Records how long the target's search took in all
+func recordTargetStat(r runResult) {
+	if !collectStats {
+		return
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	statFor(r.Bucket).elapsed = time.Duration(r.Duration * float64(time.Second))
+}
+
+// Prints the statistics collected: for each digit position, the time and probes it took
+// across the targets, then each target's totals
+func printStats() {
+	if !collectStats {
+		return
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	type positionStat struct {
+		count        int
+		total, worst time.Duration
+		probes       int64
+	}
+	positions := make(map[int]*positionStat)
+	for _, s := range targetStats {
+		for _, d := range s.digits {
+			p, ok := positions[d.position]
+			if !ok {
+				p = &positionStat{}
+				positions[d.position] = p
+			}
+			p.count++
+			p.total += d.elapsed
+			p.worst = max(p.worst, d.elapsed)
+			p.probes += d.probes
+		}
+	}
+	order := make([]int, 0, len(positions))
+	for position := range positions {
+		order = append(order, position)
+	}
+	sort.Ints(order)
+
+	fmt.Println()
+	fmt.Println("Timing statistics")
+	fmt.Printf("  %-6s %8s %10s %10s %11s %8s\n", "Digit", "Targets", "Avg time", "Max time", "Avg probes", "Probes")
+	for _, position := range order {
+		p := positions[position]
+		fmt.Printf("  %-6d %8d %10s %10s %11.1f %8d\n", position, p.count,
+			(p.total / time.Duration(p.count)).Round(time.Millisecond), p.worst.Round(time.Millisecond),
+			float64(p.probes)/float64(p.count), p.probes)
+	}
+
+	fmt.Println()
+	fmt.Printf("  %-40s %10s %8s %7s %12s\n", "Target", "Time", "Probes", "Digits", "Per digit")
+	for _, bucket := range statsOrder {
+		s := targetStats[bucket]
+		perDigit := "-"
+		if len(s.digits) > 0 {
+			var digitTime time.Duration
+			for _, d := range s.digits {
+				digitTime += d.elapsed
+			}
+			perDigit = (digitTime / time.Duration(len(s.digits))).Round(time.Millisecond).String()
+		}
+		fmt.Printf("  %-40s %10s %8d %7d %12s\n", truncate(bucket, 40), s.elapsed.Round(time.Millisecond), s.probes, len(s.digits), perDigit)
+	}
+}
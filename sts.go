@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// Region whose STS endpoint AssumeRole calls go to, set from flags. "bucket" uses the
+// bucket's region once it's known, "fastest" the endpoint that answers quickest,
+// and anything else is taken as a region name.
+var stsRegion = "bucket"
+
+var (
+	fastestRegion     string
+	fastestRegionOnce sync.Once
+)
+
+// Creates the STS client for probing a bucket, pointed at the regional endpoint
+// selected by -sts-region
+func newSTSClient(ctx context.Context, cfg aws.Config, bucket string) *sts.Client {
+	region := ""
+	switch stsRegion {
+	case "bucket":
+		if r, found := bucketRegions.get(bucket); found && isDefaultSTSRegion(r) {
+			region = r
+		}
+	case "fastest":
+		fastestRegionOnce.Do(func() {
+			fastestRegion = findFastestSTSRegion(ctx, cfg)
+		})
+		region = fastestRegion
+	default:
+		region = stsRegion
+	}
+	return stsClientFor(cfg, region)
+}
+
+// External ID passed when assuming any role, set from flags
+var externalID string
+
+// Key of the external ID of the target probes are made for
+type externalIDKey struct{}
+
+// Marks the roles assumed with the context as needing the target's external ID from a
+// targets file, overriding -external-id
+func withExternalID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, externalIDKey{}, id)
+}
+
+// Returns the external ID to assume roles with for the context's target
+func externalIDFor(ctx context.Context) string {
+	if id, ok := ctx.Value(externalIDKey{}).(string); ok {
+		return id
+	}
+	return externalID
+}
+
+// Source identity set on every assumed session, set from flags
+var sourceIdentity string
+
+// Lifetime of the assumed sessions in seconds, set from flags (0 for the SDK default of 15 minutes)
+var sessionDuration int
+
+// Role session name, set from flags ("" for the SDK default), and whether to add a
+// random suffix to it per run or per probe
+var (
+	sessionName       string
+	randomSessionName string
+)
+
+var (
+	runSessionName     string
+	runSessionNameOnce sync.Once
+)
+
+// Counts AssumeRole calls, to rotate through several roles
+var roleRotation atomic.Uint64
+
+// Splits a comma-separated list of role ARNs
+func splitRoles(roleArns string) []string {
+	var roles []string
+	for _, role := range strings.Split(roleArns, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// Returns the role to assume next from a comma-separated list, taking each in turn
+// to spread the AssumeRole calls across them
+func nextRole(roleArns string) string {
+	if !strings.Contains(roleArns, ",") {
+		return roleArns
+	}
+	roles := splitRoles(roleArns)
+	return roles[(roleRotation.Add(1)-1)%uint64(len(roles))]
+}
+
+// Creates a provider assuming the role, with the external ID if there is one and the
+// session name, source identity and duration. Given several roles, it assumes the next
+// in turn.
+func newAssumeRoleProvider(stsSvc *sts.Client, roleArn, id string, optFns ...func(*stscreds.AssumeRoleOptions)) *stscreds.AssumeRoleProvider {
+	roleArn = nextRole(roleArn)
+	name := roleSessionName()
+	optFns = append([]func(*stscreds.AssumeRoleOptions){func(o *stscreds.AssumeRoleOptions) {
+		if id != "" {
+			o.ExternalID = aws.String(id)
+		}
+		if name != "" {
+			o.RoleSessionName = name
+		}
+		if sourceIdentity != "" {
+			o.SourceIdentity = aws.String(sourceIdentity)
+		}
+		if sessionDuration > 0 {
+			o.Duration = time.Duration(sessionDuration) * time.Second
+		}
+	}}, optFns...)
+	return stscreds.NewAssumeRoleProvider(stsSvc, roleArn, optFns...)
+}
+
+// Returns the role session name for the next AssumeRole call, "" for the SDK default
+func roleSessionName() string {
+	switch randomSessionName {
+	case "run":
+		runSessionNameOnce.Do(func() {
+			runSessionName = randomizeSessionName(sessionName)
+		})
+		return runSessionName
+	case "probe":
+		return randomizeSessionName(sessionName)
+	}
+	return sessionName
+}
+
+// Appends a random suffix to the session name, or returns a random name if there's none.
+// Session names are at most 64 characters.
+func randomizeSessionName(name string) string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	suffix := hex.EncodeToString(b)
+	if name == "" {
+		return "session-" + suffix
+	}
+	if len(name) > 64-len(suffix)-1 {
+		name = name[:64-len(suffix)-1]
+	}
+	return name + "-" + suffix
+}
+
+// Whether to get probe sessions with GetFederationToken from the base IAM user instead
+// of assuming a role, set from flags
+var federation bool
+
+// Returns the credentials of a probe session restricted by the policy (nil for none):
+// an assumed role session with the context's external ID, or a federated user session
+// with -federation
+func newSessionCredentials(ctx context.Context, stsSvc *sts.Client, roleArn string, policy map[string]interface{}) *aws.CredentialsCache {
+	if federation {
+		return aws.NewCredentialsCache(&federationTokenProvider{client: stsSvc, policy: marshalPolicy(policy)})
+	}
+	return aws.NewCredentialsCache(newAssumeRoleProvider(stsSvc, roleArn, externalIDFor(ctx), func(opt *stscreds.AssumeRoleOptions) {
+		if policy != nil {
+			opt.Policy = aws.String(marshalPolicy(policy))
+		}
+	}))
+}
+
+// Gets federated user credentials with GetFederationToken. Their permissions are the
+// intersection of the IAM user's policies and the session policy, just like an assumed
+// role session's.
+type federationTokenProvider struct {
+	client *sts.Client
+	policy string
+}
+
+// Gets a federation token restricted by the policy
+func (p *federationTokenProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	// Federated user names are 2 to 32 characters
+	name := roleSessionName()
+	if name == "" {
+		name = "S3AccountFinder"
+	}
+	if len(name) > 32 {
+		name = name[len(name)-32:]
+	}
+	input := &sts.GetFederationTokenInput{
+		Name:   aws.String(name),
+		Policy: aws.String(p.policy),
+	}
+	if sessionDuration > 0 {
+		input.DurationSeconds = aws.Int32(int32(sessionDuration))
+	}
+	out, err := p.client.GetFederationToken(ctx, input)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(out.Credentials.SessionToken),
+		Source:          "GetFederationToken",
+		CanExpire:       true,
+		Expires:         aws.ToTime(out.Credentials.Expiration),
+	}, nil
+}
+
+// Reports whether STS is enabled by default in the region
+func isDefaultSTSRegion(region string) bool {
+	for _, r := range currentPartition().stsRegions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// Times an unsigned HEAD request to every default STS endpoint at once, through the
+// configuration's HTTP client so that -proxy, -insecure and custom CA bundles apply, and
+// returns the region that answered first, or "" if none did. No API calls are made.
+func findFastestSTSRegion(ctx context.Context, cfg aws.Config) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	type timing struct {
+		region  string
+		elapsed time.Duration
+	}
+	var client aws.HTTPClient = http.DefaultClient
+	if cfg.HTTPClient != nil {
+		client = cfg.HTTPClient
+	}
+	p := currentPartition()
+	stsHost := "sts"
+	if useFIPS {
+		stsHost = "sts-fips"
+	}
+	ch := make(chan timing, len(p.stsRegions))
+	for _, region := range p.stsRegions {
+		go func(region string) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://"+stsHost+"."+region+"."+p.dnsSuffix+"/", nil)
+			if err != nil {
+				ch <- timing{region: region}
+				return
+			}
+			setUserAgent(req)
+			start := time.Now()
+			resp, err := client.Do(req)
+			if err != nil {
+				ch <- timing{region: region}
+				return
+			}
+			resp.Body.Close()
+			ch <- timing{region, time.Since(start)}
+		}(region)
+	}
+
+	for range p.stsRegions {
+		if t := <-ch; t.elapsed > 0 {
+			slog.Info("Using the fastest STS endpoint", "region", t.region, "elapsed", t.elapsed.Round(time.Millisecond))
+			return t.region
+		}
+	}
+	slog.Warn("No STS endpoint answered, using the configured region")
+	return ""
+}
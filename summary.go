@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// Whether to print the summary at the end of a batch run, set from flags
+var showSummary = true
+
+// Returns the reason a target failed, for grouping failures in the batch summary
+func failureReason(err error) string {
+	var apiErr smithy.APIError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errSkipped):
+		return "skipped"
+	case errors.Is(err, errNoBudget):
+		return "API call budget exhausted"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case errors.Is(err, context.Canceled):
+		return "interrupted"
+	case errors.Is(err, errCanary):
+		return "possible canary"
+	case errors.Is(err, errNoAccess):
+		return "no access"
+	case errors.Is(err, errIncomplete):
+		return "digit not found"
+	case errors.Is(err, errInconclusive):
+		return "inconclusive probe"
+	case errors.As(err, &apiErr):
+		if _, ok := throttleErrorCodes[apiErr.ErrorCode()]; ok {
+			return "throttled"
+		}
+		return apiErr.ErrorCode()
+	case errors.As(err, &netErr):
+		return "network error"
+	}
+	return "other error"
+}
+
+// Prints the summary of a batch run: targets scanned, accounts found, failures by
+// reason, API calls and elapsed time
+func printBatchSummary(resolved []resolvedTarget, outcome func(r resolvedTarget) (string, string, error), elapsed time.Duration) {
+	var found, confirmed, partial int
+	owners := make(map[string]bool)
+	failures := make(map[string]int)
+	for _, r := range resolved {
+		if r.Err != nil {
+			failures["invalid target"]++
+			continue
+		}
+		accountID, status, err := outcome(r)
+		switch {
+		case len(accountID) >= maxDigits && err == nil:
+			if len(accountID) == 12 {
+				found++
+				owners[accountID] = true
+			} else {
+				partial++
+			}
+			if status == statusConfirmed {
+				confirmed++
+			}
+		case err != nil:
+			failures[failureReason(err)]++
+			if accountID != "" {
+				partial++
+			}
+		default:
+			partial++
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Summary")
+	fmt.Printf("  Targets scanned:       %d\n", len(resolved))
+	fmt.Printf("  Accounts found:        %d (%d confirmed)\n", found, confirmed)
+	if partial > 0 {
+		fmt.Printf("  Partial prefixes:      %d\n", partial)
+	}
+	fmt.Printf("  Unique owner accounts: %d\n", len(owners))
+	total := 0
+	reasons := make([]string, 0, len(failures))
+	for reason, n := range failures {
+		total += n
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if failures[reasons[i]] != failures[reasons[j]] {
+			return failures[reasons[i]] > failures[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	fmt.Printf("  Failures:              %d\n", total)
+	for _, reason := range reasons {
+		fmt.Printf("    %-20s %d\n", reason+":", failures[reason])
+	}
+	fmt.Printf("  API calls:             %d\n", apiCalls.Load())
+	fmt.Printf("  Elapsed:               %s\n", elapsed.Round(time.Second))
+}
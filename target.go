@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Set by -raw-key: use keys exactly as given, without any decoding
+var rawKeys bool
+
+// Converts the path to bucket and key as toS3Args does, then resolves custom hostnames
+// to their bucket and caches any region the path gives away. Only http(s) URLs on a host
+// other than an S3 endpoint are resolved: bucket names, s3:// URIs and ARNs are used as
+// given, without any DNS or HTTP traffic.
+func parseTarget(path string) (string, string, error) {
+	bucket, key, err := toS3Args(path)
+	if err != nil {
+		return "", "", err
+	}
+	if isCustomHostURL(path) {
+		bucket = resolveBucketHost(bucket)
+	}
+	if region, ok := presignedRegion(path); ok {
+		bucketRegions.set(bucket, region)
+	}
+	return bucket, key, nil
+}
+
+// Reports whether the path is an http(s) URL on a custom domain or CloudFront rather than
+// an S3 endpoint
+func isCustomHostURL(path string) bool {
+	lower := strings.ToLower(path)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return false
+	}
+	u, err := url.Parse(path)
+	return err == nil && !isS3Host(strings.ToLower(u.Hostname()))
+}
+
+// Returns the signing region of a SigV4 presigned URL, taken from the
+// X-Amz-Credential query parameter (<access key>/<date>/<region>/s3/aws4_request)
+func presignedRegion(rawURL string) (string, bool) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	// Query parameter names are case-sensitive, but hand-edited URLs aren't always careful
+	for name, values := range u.Query() {
+		if !strings.EqualFold(name, "X-Amz-Credential") || len(values) == 0 {
+			continue
+		}
+		scope := strings.Split(values[0], "/")
+		if len(scope) == 5 && scope[3] == "s3" && scope[2] != "" {
+			return scope[2], true
+		}
+	}
+	return "", false
+}
+
+// Converts the path to bucket and key. Accepts bare bucket/key paths, s3:// URIs,
+// S3 ARNs and http(s) URLs for virtual-hosted-style, path-style and website endpoints.
+// Access point targets are returned with the access point ARN as the bucket, which
+// the SDK accepts in place of a bucket name and routes to the access point endpoint.
+func toS3Args(path string) (string, string, error) {
+	if arn.IsARN(path) {
+		return arnToS3Args(path)
+	}
+	if strings.HasPrefix(path, "s3://") {
+		path = path[5:]
+	} else if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") || isS3Host(hostOf(path)) {
+		bucket, key := urlToS3Args(path)
+		return bucket, key, nil
+	}
+	bucket, key := splitBucketKey(path)
+	return bucket, normalizeKey(key), nil
+}
+
+// Splits a bucket/key path on the first slash
+func splitBucketKey(path string) (string, string) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) > 1 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+// Converts an S3 bucket/object ARN or access point ARN to bucket and key
+func arnToS3Args(s string) (string, string, error) {
+	a, err := arn.Parse(s)
+	if err != nil {
+		return "", "", err
+	}
+	if a.Service == "s3-outposts" {
+		return outpostsARNToS3Args(a)
+	}
+	if a.Service != "s3" && a.Service != "s3-object-lambda" {
+		return "", "", fmt.Errorf("unsupported ARN service %q", a.Service)
+	}
+
+	// arn:aws:s3:::bucket/key
+	if a.Region == "" && a.AccountID == "" {
+		bucket, key := splitBucketKey(a.Resource)
+		return bucket, key, nil
+	}
+
+	// arn:aws:s3[-object-lambda]:region:account:accesspoint/name[/object/key], also accepting accesspoint:name
+	if strings.HasPrefix(a.Resource, "accesspoint:") {
+		a.Resource = strings.Replace(a.Resource, ":", "/", 1)
+	}
+	parts := strings.SplitN(a.Resource, "/", 4)
+	if len(parts) < 2 || parts[0] != "accesspoint" || parts[1] == "" {
+		return "", "", fmt.Errorf("unsupported S3 ARN resource %q", a.Resource)
+	}
+	if a.AccountID == "" || (a.Region == "" && (a.Service != "s3" || !strings.HasSuffix(parts[1], ".mrap"))) {
+		return "", "", fmt.Errorf("access point ARN %q must include a region and account", s)
+	}
+	a.Resource = "accesspoint/" + parts[1]
+	key := ""
+	if len(parts) == 4 && parts[2] == "object" {
+		key = parts[3]
+	} else if len(parts) > 2 {
+		return "", "", fmt.Errorf("unsupported S3 ARN resource %q", s)
+	}
+	return a.String(), key, nil
+}
+
+// Reports whether the bucket is an S3 access point alias (name-xxxxxxxx-s3alias, or
+// xxxxxxxx--op-s3 on Outposts and xxxxxxxx--ol-s3 for Object Lambda). Aliases are
+// accepted anywhere a bucket name is, but must be addressed virtual-hosted-style.
+func isAccessPointAlias(bucket string) bool {
+	return strings.HasSuffix(bucket, "-s3alias") || strings.HasSuffix(bucket, "--op-s3") || strings.HasSuffix(bucket, "--ol-s3")
+}
+
+// Reports whether the bucket is an S3 Object Lambda access point ARN or alias
+func isObjectLambda(bucket string) bool {
+	return arn.IsARN(bucket) && strings.Contains(bucket, ":s3-object-lambda:") || strings.HasSuffix(bucket, "--ol-s3")
+}
+
+// Reports whether the bucket is an S3 Express One Zone directory bucket (base-name--zone-id--x-s3)
+func isDirectoryBucket(bucket string) bool {
+	return strings.HasSuffix(bucket, "--x-s3") && !arn.IsARN(bucket)
+}
+
+// Reports whether the bucket is a Multi-Region Access Point, either as an ARN
+// (arn:aws:s3::account:accesspoint/xxxxxxxx.mrap) or a bare alias (xxxxxxxx.mrap)
+func isMultiRegionAccessPoint(bucket string) bool {
+	return strings.HasSuffix(bucket, ".mrap")
+}
+
+// Reports whether the bucket is a bare Multi-Region Access Point alias rather than an ARN
+func isMRAPAlias(bucket string) bool {
+	return isMultiRegionAccessPoint(bucket) && !arn.IsARN(bucket)
+}
+
+// Returns the region of an ARN bucket argument
+func arnRegion(bucket string) (string, bool) {
+	if !arn.IsARN(bucket) {
+		return "", false
+	}
+	a, err := arn.Parse(bucket)
+	if err != nil || a.Region == "" {
+		return "", false
+	}
+	return a.Region, true
+}
+
+// Converts an S3 URL to bucket and key
+func urlToS3Args(rawURL string) (string, string) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// Not a URL after all (e.g. a stray % in the key), fall back to treating it as bucket/key
+		return splitBucketKey(strings.SplitN(rawURL, "://", 2)[1])
+	}
+
+	path := strings.TrimPrefix(u.EscapedPath(), "/")
+	host := strings.ToLower(u.Hostname())
+	if !isS3Host(host) {
+		// Custom domains pointing at website buckets use the hostname as the bucket name
+		return host, decodeURLKey(path)
+	}
+
+	// Multi-Region Access Point: <alias>.mrap.accesspoint.s3-global.amazonaws.com/key
+	if alias, ok := strings.CutSuffix(trimS3Domain(host), ".accesspoint.s3-global"); ok {
+		return alias, decodeURLKey(path)
+	}
+
+	labels := strings.Split(trimS3Domain(host), ".")
+	idx := s3LabelIndex(labels)
+	if idx == 0 {
+		// Path-style: s3.<region>.amazonaws.com/bucket/key
+		bucket, key := splitBucketKey(path)
+		return bucket, decodeURLKey(key)
+	}
+	// Virtual-hosted-style or website: bucket.s3[-website][.-]<region>.amazonaws.com/key
+	return strings.Join(labels[:idx], "."), decodeURLKey(path)
+}
+
+// Decodes a key taken from a URL path. S3 reads "+" in a path as a space (a literal
+// plus arrives as %2B), so that is undone before percent-decoding. With -raw-key,
+// or if the path isn't validly encoded, the key is used exactly as it appears.
+func decodeURLKey(key string) string {
+	if rawKeys {
+		return key
+	}
+	decoded, err := url.PathUnescape(strings.ReplaceAll(key, "+", " "))
+	if err != nil {
+		return key
+	}
+	return decoded
+}
+
+// Percent-decodes a key from an s3:// URI or bucket/key path, as copied from a URL or
+// the console (a%20b.txt -> a b.txt). A "+" is left alone since these aren't URL
+// paths. With -raw-key, or if the key isn't validly encoded, it is used as given.
+func normalizeKey(key string) string {
+	if rawKeys || !strings.Contains(key, "%") {
+		return key
+	}
+	decoded, err := url.PathUnescape(key)
+	if err != nil {
+		return key
+	}
+	return decoded
+}
+
+// Returns the host portion of a scheme-less URL
+func hostOf(path string) string {
+	return strings.ToLower(strings.SplitN(path, "/", 2)[0])
+}
+
+// Reports whether the host is an S3 service endpoint
+func isS3Host(host string) bool {
+	trimmed := trimS3Domain(host)
+	if trimmed == host {
+		return false
+	}
+	return s3LabelIndex(strings.Split(trimmed, ".")) >= 0
+}
+
+// Strips the amazonaws.com (or amazonaws.com.cn) suffix from the host
+func trimS3Domain(host string) string {
+	for _, suffix := range []string{".amazonaws.com.cn", ".amazonaws.com"} {
+		if strings.HasSuffix(host, suffix) {
+			return strings.TrimSuffix(host, suffix)
+		}
+	}
+	return host
+}
+
+// Returns the index of the rightmost "s3" service label (s3, s3-website, s3-<region>, s3express-<zone>, ...) or -1.
+// Searching from the right keeps bucket names that themselves start with "s3-" intact.
+func s3LabelIndex(labels []string) int {
+	for i := len(labels) - 1; i >= 0; i-- {
+		if labels[i] == "s3" || strings.HasPrefix(labels[i], "s3-") || strings.HasPrefix(labels[i], "s3express-") {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,114 @@
+package main
+
+import "testing"
+
+func TestToS3Args(t *testing.T) {
+	tests := []struct {
+		path, bucket, key string
+	}{
+		{"bucket", "bucket", ""},
+		{"bucket/path/to/key.txt", "bucket", "path/to/key.txt"},
+		{"s3://bucket/key%20name", "bucket", "key name"},
+		{"https://bucket.s3.amazonaws.com/key", "bucket", "key"},
+		{"https://bucket.s3.eu-west-1.amazonaws.com/dir/key", "bucket", "dir/key"},
+		{"https://s3.us-east-2.amazonaws.com/bucket/key", "bucket", "key"},
+		{"http://my.bucket.s3-website-us-east-1.amazonaws.com/index.html", "my.bucket", "index.html"},
+		{"bucket.s3.amazonaws.com/key", "bucket", "key"},
+		{"https://bucket.s3.cn-north-1.amazonaws.com.cn/key", "bucket", "key"},
+		{"https://mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com/key", "mfzwi23gnjvgw.mrap", "key"},
+		{"arn:aws:s3:::bucket", "bucket", ""},
+		{"arn:aws:s3:::bucket/dir/key", "bucket", "dir/key"},
+		{"arn:aws:s3:us-west-2:111122223333:accesspoint/ap", "arn:aws:s3:us-west-2:111122223333:accesspoint/ap", ""},
+		{"arn:aws:s3:us-west-2:111122223333:accesspoint:ap", "arn:aws:s3:us-west-2:111122223333:accesspoint/ap", ""},
+		{"arn:aws:s3:us-west-2:111122223333:accesspoint/ap/object/dir/key", "arn:aws:s3:us-west-2:111122223333:accesspoint/ap", "dir/key"},
+		{"arn:aws:s3::111122223333:accesspoint/mfzwi23gnjvgw.mrap", "arn:aws:s3::111122223333:accesspoint/mfzwi23gnjvgw.mrap", ""},
+		{"arn:aws:s3-object-lambda:us-east-1:111122223333:accesspoint/olap", "arn:aws:s3-object-lambda:us-east-1:111122223333:accesspoint/olap", ""},
+		{"arn:aws:s3-outposts:us-west-2:111122223333:outpost/op-01ac5d28a6a232904/bucket/b", "arn:aws:s3-outposts:us-west-2:111122223333:outpost/op-01ac5d28a6a232904/bucket/b", ""},
+	}
+	for _, tt := range tests {
+		bucket, key, err := toS3Args(tt.path)
+		if err != nil {
+			t.Errorf("toS3Args(%q) failed: %v", tt.path, err)
+			continue
+		}
+		if bucket != tt.bucket || key != tt.key {
+			t.Errorf("toS3Args(%q) = %q, %q, want %q, %q", tt.path, bucket, key, tt.bucket, tt.key)
+		}
+	}
+}
+
+func TestArnToS3ArgsErrors(t *testing.T) {
+	for _, s := range []string{
+		"arn:aws:iam::111122223333:role/r",
+		"arn:aws:s3:us-west-2:111122223333:bucket/b",
+		"arn:aws:s3::111122223333:accesspoint/ap",
+		"arn:aws:s3:us-west-2:111122223333:accesspoint/ap/extra",
+		"arn:aws:s3-object-lambda::111122223333:accesspoint/olap.mrap",
+		"arn:aws:s3-outposts:us-west-2:111122223333:outpost/op-01ac5d28a6a232904/bucket/b/key",
+	} {
+		if bucket, key, err := arnToS3Args(s); err == nil {
+			t.Errorf("arnToS3Args(%q) = %q, %q, want an error", s, bucket, key)
+		}
+	}
+}
+
+func TestParseTargetKeepsBucketNames(t *testing.T) {
+	for _, path := range []string{"s3://a.b.c", "a.b.c", "arn:aws:s3:::a.b.c", "https://a.b.c.s3.amazonaws.com/"} {
+		bucket, _, err := parseTarget(path)
+		if err != nil {
+			t.Errorf("parseTarget(%q) failed: %v", path, err)
+			continue
+		}
+		if bucket != "a.b.c" {
+			t.Errorf("parseTarget(%q) = %q, want a.b.c", path, bucket)
+		}
+	}
+}
+
+func TestIsCustomHostURL(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"https://assets.example.com/logo.png", true},
+		{"HTTP://d111111abcdef8.cloudfront.net/", true},
+		{"https://bucket.s3.amazonaws.com/key", false},
+		{"s3://assets.example.com", false},
+		{"assets.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := isCustomHostURL(tt.path); got != tt.want {
+			t.Errorf("isCustomHostURL(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestJobKey(t *testing.T) {
+	const role = "arn:aws:iam::111122223333:role/r"
+	base := resolveTarget(target{Path: "bucket"}, role)
+	tests := []struct {
+		target target
+		same   bool
+	}{
+		{target{Path: "s3://bucket"}, true},
+		{target{Path: "https://bucket.s3.eu-west-1.amazonaws.com/"}, true},
+		{target{Path: "arn:aws:s3:::bucket", Label: "prod"}, true},
+		{target{Path: "bucket", RoleArn: role}, true},
+		{target{Path: "other"}, false},
+		{target{Path: "bucket/key"}, false},
+		{target{Path: "bucket", RoleArn: "arn:aws:iam::111122223333:role/other"}, false},
+		{target{Path: "bucket", Profile: "audit"}, false},
+		{target{Path: "bucket", ExternalID: "ext"}, false},
+		{target{Path: "bucket", KnownDigits: "1111"}, false},
+	}
+	for _, tt := range tests {
+		r := resolveTarget(tt.target, role)
+		if r.Err != nil {
+			t.Errorf("resolveTarget(%+v) failed: %v", tt.target, r.Err)
+			continue
+		}
+		if same := r.jobKey() == base.jobKey(); same != tt.same {
+			t.Errorf("%+v shares the search of bucket: %v, want %v", tt.target, same, tt.same)
+		}
+	}
+}
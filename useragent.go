@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// User-Agent sent with every request, set from flags: a replacement for the default
+// one, and text appended to it, e.g. an engagement ID
+var (
+	userAgent       string
+	userAgentAppend string
+)
+
+// Returns the User-Agent to send in place of the default one
+func userAgentFor(defaultUA string) string {
+	ua := defaultUA
+	if userAgent != "" {
+		ua = userAgent
+	}
+	if userAgentAppend != "" {
+		ua += " " + userAgentAppend
+	}
+	return ua
+}
+
+// Rewrites the User-Agent the SDK sets on every STS and S3 request. It isn't signed, so
+// it can be changed after the SDK's own middleware has run.
+func applyUserAgent(cfg *aws.Config) {
+	if userAgent == "" && userAgentAppend == "" {
+		return
+	}
+	cfg.APIOptions = append(cfg.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("CustomUserAgent",
+			func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					req.Header.Set("User-Agent", userAgentFor(req.Header.Get("User-Agent")))
+					if userAgent != "" {
+						req.Header.Del("X-Amz-User-Agent")
+					}
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+	})
+}
+
+// Sets the User-Agent on a request made outside the SDK
+func setUserAgent(req *http.Request) {
+	if userAgent != "" || userAgentAppend != "" {
+		req.Header.Set("User-Agent", userAgentFor("Go-http-client/1.1"))
+	}
+}
+
+// Sets the User-Agent on every request through the plain HTTP clients
+type userAgentTransport struct {
+	next http.RoundTripper
+}
+
+// Sends a copy of the request with the User-Agent set
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	setUserAgent(req)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// Publicly known AWS accounts: AWS service accounts, AMI publishers and SaaS vendors
+//
+//go:embed vendors.txt
+var embeddedVendors string
+
+// Owners of known accounts by account ID, from the embedded dataset, -vendors files and
+// the names in -known-accounts
+var vendorNames = func() map[string]string {
+	accounts, err := parseKnownAccounts(strings.NewReader(embeddedVendors))
+	if err != nil {
+		panic("vendors.txt: " + err.Error())
+	}
+	names := make(map[string]string, len(accounts))
+	addVendorNames(names, accounts)
+	return names
+}()
+
+// Adds the named accounts to the dataset, replacing the owners of those already in it
+func addVendorNames(names map[string]string, accounts []knownAccount) {
+	for _, account := range accounts {
+		if account.Name != "" {
+			names[account.ID] = account.Name
+		}
+	}
+}
+
+// Reads a -vendors file, in the -known-accounts format, into the dataset
+func loadVendors(filename string) error {
+	accounts, err := readKnownAccounts(filename)
+	if err != nil {
+		return err
+	}
+	addVendorNames(vendorNames, accounts)
+	return nil
+}
+
+// Returns the known owner of a complete account ID, or "" if it isn't a known account
+func vendorName(accountID string) string {
+	if len(accountID) != 12 {
+		return ""
+	}
+	return vendorNames[accountID]
+}
+
+// Returns the owner of the account ID for appending to a printed result, e.g. " [Datadog]":
+// the account in the caller's organization, or else the known owner
+func ownerSuffix(accountID string) string {
+	if account := orgAccountFor(accountID); account != nil {
+		return " [" + account.Name + " <" + account.Email + ">]"
+	}
+	if name := vendorName(accountID); name != "" {
+		return " [" + name + "]"
+	}
+	return ""
+}
+
+// Returns the name a result's account is reported under: its name in the caller's
+// organization, or else its known owner
+func (r runResult) ownerName() string {
+	if r.OrgAccount != "" {
+		return r.OrgAccount + " <" + r.OrgEmail + ">"
+	}
+	return r.Owner
+}
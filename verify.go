@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Most characters a session policy can have
+const maxPolicyLength = 2048
+
+// Tests whether a bucket is owned by one of a list of suspected accounts, without
+// enumerating the account ID digit by digit
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	roleArn := fs.String("role_arn", "", "ARN of the role to assume")
+	path := fs.String("path", "", "s3 bucket or bucket/path to test with")
+	accountsFile := fs.String("accounts", "", "file of candidate account IDs, one per line, optionally followed by a name")
+	addRequestFlags(fs)
+	fs.BoolVar(&noColor, "no-color", false, "don't colour the output, even on a terminal")
+	addLogFlags(fs)
+	parseFlags(fs, args)
+	initLogging()
+
+	var candidates []knownAccount
+	if *accountsFile != "" {
+		accounts, err := readKnownAccounts(*accountsFile)
+		if err != nil {
+			fatalConfigf("failed to read accounts: %v", err)
+		}
+		candidates = accounts
+	}
+	for _, id := range fs.Args() {
+		if len(id) != 12 || validateKnownDigits(id) != nil {
+			fatalConfigf("%q is not a 12 digit account ID", id)
+		}
+		candidates = append(candidates, knownAccount{ID: id})
+	}
+	if *path == "" || *roleArn == "" && !federation || len(candidates) == 0 {
+		fatalConfigf("usage: verify -role_arn <role_arn> -path <path> [-accounts <file>] [<account_id>...]")
+	}
+
+	bucket, key, err := parseTarget(*path)
+	if err != nil {
+		fatalConfigf("invalid path: %v", err)
+	}
+
+	setPartitionFromRole(*roleArn)
+	ctx, cancel := runContext()
+	defer cancel()
+	cfg := loadConfig(ctx)
+
+	key, err = checkAccess(ctx, cfg, bucket, key, *roleArn)
+	if err != nil {
+		slog.Error(err.Error(), "bucket", bucket)
+		os.Exit(exitCodeFor("", err))
+	}
+
+	owner, ok, err := verifyAccounts(ctx, cfg, bucket, key, *roleArn, candidates)
+	if err != nil {
+		slog.Error("Failed to check the candidates", "bucket", bucket, "err", err)
+		os.Exit(exitCodeFor("", err))
+	}
+	if ok {
+		recordOwner(bucket, owner.ID, "verify")
+		colorPrintf(os.Stdout, colorGreen, "Bucket owner account ID: %s\n", owner.name())
+		return
+	}
+	colorPrintf(os.Stdout, colorRed, "None of the %d candidate accounts own %s\n", len(candidates), bucket)
+	os.Exit(exitNoAccess)
+}
+
+// Finds which candidate owns the bucket by bisecting the list with StringEquals
+// probes, so that N candidates take about log2(N) probes rather than N
+func verifyAccounts(ctx context.Context, cfg aws.Config, bucket, key, roleArn string, candidates []knownAccount) (knownAccount, bool, error) {
+	chunks, err := policyChunks(bucket, candidates)
+	if err != nil {
+		return knownAccount{}, false, err
+	}
+	for _, chunk := range chunks {
+		allowed, err := canAccessWithPolicy(ctx, cfg, bucket, key, roleArn, getExactPolicy(bucket, accountIDs(chunk)))
+		if err != nil {
+			return knownAccount{}, false, err
+		}
+		if !allowed {
+			continue
+		}
+		for len(chunk) > 1 {
+			half := chunk[:len(chunk)/2]
+			allowed, err := canAccessWithPolicy(ctx, cfg, bucket, key, roleArn, getExactPolicy(bucket, accountIDs(half)))
+			if err != nil {
+				return knownAccount{}, false, err
+			}
+			if allowed {
+				chunk = half
+			} else {
+				chunk = chunk[len(chunk)/2:]
+			}
+		}
+		return chunk[0], true, nil
+	}
+	return knownAccount{}, false, nil
+}
+
+// Splits the candidates into as few chunks as possible whose StringEquals policy, with
+// any -session-policy-file statements merged in, fits the session policy limit. The
+// halves bisected from a chunk always fit too.
+func policyChunks(bucket string, candidates []knownAccount) ([][]knownAccount, error) {
+	var chunks [][]knownAccount
+	for start := 0; start < len(candidates); {
+		end := start
+		for end < len(candidates) && len(marshalPolicy(getExactPolicy(bucket, accountIDs(candidates[start:end+1])))) <= maxPolicyLength {
+			end++
+		}
+		if end == start {
+			return nil, errors.New("the session policy is too long for even a single account ID, shorten -session-policy-file")
+		}
+		chunks = append(chunks, candidates[start:end])
+		start = end
+	}
+	return chunks, nil
+}
+
+// Returns the IDs of the accounts
+func accountIDs(accounts []knownAccount) []string {
+	ids := make([]string, len(accounts))
+	for i, account := range accounts {
+		ids[i] = account.ID
+	}
+	return ids
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPolicyChunks(t *testing.T) {
+	bucket := strings.Repeat("b", 63)
+	var candidates []knownAccount
+	for i := 0; i < 250; i++ {
+		candidates = append(candidates, knownAccount{ID: fmt.Sprintf("%012d", i)})
+	}
+
+	chunks, err := policyChunks(bucket, candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks for %d candidates, want several", len(chunks), len(candidates))
+	}
+	n := 0
+	for _, chunk := range chunks {
+		if l := len(marshalPolicy(getExactPolicy(bucket, accountIDs(chunk)))); l > maxPolicyLength {
+			t.Errorf("chunk of %d accounts has a %d character policy, over %d", len(chunk), l, maxPolicyLength)
+		}
+		for _, account := range chunk {
+			if account != candidates[n] {
+				t.Fatalf("chunks don't cover the candidates in order: got %s at %d", account.ID, n)
+			}
+			n++
+		}
+	}
+	if n != len(candidates) {
+		t.Errorf("chunks cover %d candidates, want %d", n, len(candidates))
+	}
+}
+
+func TestPolicyChunksTooLong(t *testing.T) {
+	defer func(statements []map[string]interface{}) { baseStatements = statements }(baseStatements)
+	baseStatements = []map[string]interface{}{{"Sid": strings.Repeat("x", maxPolicyLength)}}
+
+	if _, err := policyChunks("bucket", []knownAccount{{ID: "111122223333"}}); err == nil {
+		t.Error("policyChunks accepted a policy over the limit")
+	}
+}